@@ -0,0 +1,146 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bmt provides a mantaray.Saver computing the same references as
+// Swarm by hashing data into binary merkle tree chunks.
+package bmt
+
+import (
+	"context"
+	"encoding/binary"
+	"hash"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// ChunkSize is the maximum payload size of a chunk.
+	ChunkSize = 4096
+	// SpanSize is the size of the length prefix of a chunk.
+	SpanSize = 8
+	// RefSize is the size of chunk addresses.
+	RefSize = 32
+
+	// branches is the number of references in an intermediate chunk.
+	branches = ChunkSize / RefSize
+)
+
+// ChunkPutter stores chunks. The chunk holds the span followed by the
+// payload.
+type ChunkPutter interface {
+	Put(ctx context.Context, addr, chunk []byte) error
+}
+
+// Saver splits data into a tree of chunks the way Swarm does and returns
+// the address of its root chunk.
+type Saver struct {
+	p ChunkPutter
+}
+
+// NewSaver returns a saver storing chunks through p. A nil p only computes
+// references.
+func NewSaver(p ChunkPutter) *Saver {
+	return &Saver{p: p}
+}
+
+// Reference returns the Swarm reference of b without storing it.
+func Reference(b []byte) []byte {
+	ref, _ := NewSaver(nil).Save(context.Background(), b)
+	return ref
+}
+
+// ref is the address of a chunk and the length of the data below it.
+type ref struct {
+	addr []byte
+	span uint64
+}
+
+// Save implements mantaray.Saver. Data larger than a chunk is split into
+// leaf chunks referenced by intermediate chunks of up to 128 references.
+func (s *Saver) Save(ctx context.Context, b []byte) ([]byte, error) {
+	h := sha3.NewLegacyKeccak256()
+
+	var level []ref
+	for i := 0; i == 0 || i < len(b); i += ChunkSize {
+		end := i + ChunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+		r, err := s.put(ctx, h, uint64(end-i), b[i:end])
+		if err != nil {
+			return nil, err
+		}
+		level = append(level, r)
+	}
+
+	for len(level) > 1 {
+		next := make([]ref, 0, (len(level)+branches-1)/branches)
+		for i := 0; i < len(level); i += branches {
+			end := i + branches
+			if end > len(level) {
+				end = len(level)
+			}
+			if end-i == 1 {
+				// a single trailing reference is carried to the next level
+				next = append(next, level[i])
+				continue
+			}
+			var span uint64
+			payload := make([]byte, 0, (end-i)*RefSize)
+			for _, r := range level[i:end] {
+				span += r.span
+				payload = append(payload, r.addr...)
+			}
+			r, err := s.put(ctx, h, span, payload)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, r)
+		}
+		level = next
+	}
+	return level[0].addr, nil
+}
+
+func (s *Saver) put(ctx context.Context, h hash.Hash, span uint64, payload []byte) (ref, error) {
+	select {
+	case <-ctx.Done():
+		return ref{}, ctx.Err()
+	default:
+	}
+	chunk := make([]byte, SpanSize+len(payload))
+	binary.LittleEndian.PutUint64(chunk, span)
+	copy(chunk[SpanSize:], payload)
+	addr := chunkAddress(h, chunk)
+	if s.p != nil {
+		if err := s.p.Put(ctx, addr, chunk); err != nil {
+			return ref{}, err
+		}
+	}
+	return ref{addr: addr, span: span}, nil
+}
+
+// ChunkAddress returns the address of a chunk holding the span followed
+// by a payload of at most ChunkSize bytes.
+func ChunkAddress(chunk []byte) []byte {
+	return chunkAddress(sha3.NewLegacyKeccak256(), chunk)
+}
+
+// chunkAddress hashes the span together with the root of the binary merkle
+// tree over the zero padded payload.
+func chunkAddress(h hash.Hash, chunk []byte) []byte {
+	segments := make([]byte, ChunkSize)
+	copy(segments, chunk[SpanSize:])
+	for size := ChunkSize; size > RefSize; size /= 2 {
+		for i := 0; i < size/2; i += RefSize {
+			h.Reset()
+			_, _ = h.Write(segments[2*i : 2*i+2*RefSize])
+			h.Sum(segments[i:i])
+		}
+	}
+	h.Reset()
+	_, _ = h.Write(chunk[:SpanSize])
+	_, _ = h.Write(segments[:RefSize])
+	return h.Sum(nil)
+}
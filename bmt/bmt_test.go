@@ -0,0 +1,114 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bmt_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"github.com/ethersphere/manifest/bmt"
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+type chunkStore map[string][]byte
+
+func (s chunkStore) Put(_ context.Context, addr, chunk []byte) error {
+	s[string(addr)] = chunk
+	return nil
+}
+
+type loaderFunc func(ctx context.Context, ref []byte) ([]byte, error)
+
+func (f loaderFunc) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	return f(ctx, ref)
+}
+
+func TestReference(t *testing.T) {
+	ref := bmt.Reference([]byte("hello world"))
+	if got := hex.EncodeToString(ref); got != "92672a471f4419b255d7cb0cf313474a6f5856fb347c5ece85fb706d644b630f" {
+		t.Fatalf("unexpected reference %s", got)
+	}
+}
+
+func TestSaverChunkTree(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{"empty", 0, 1},
+		{"single chunk", bmt.ChunkSize, 1},
+		{"two chunks", bmt.ChunkSize + 1, 3},
+		{"full intermediate", 128 * bmt.ChunkSize, 129},
+		// the single trailing leaf is carried up next to the full
+		// intermediate chunk
+		{"carried reference", 128*bmt.ChunkSize + 1, 131},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := make([]byte, tc.size)
+			rand.New(rand.NewSource(1)).Read(data)
+			cs := chunkStore{}
+			ref, err := bmt.NewSaver(cs).Save(context.Background(), data)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(cs) != tc.chunks {
+				t.Fatalf("expected %d chunks, got %d", tc.chunks, len(cs))
+			}
+			if !bytes.Equal(ref, bmt.Reference(data)) {
+				t.Fatal("expected reference to match")
+			}
+			root, ok := cs[string(ref)]
+			if !ok {
+				t.Fatal("expected root chunk to be stored")
+			}
+			if span := binary.LittleEndian.Uint64(root); span != uint64(tc.size) {
+				t.Fatalf("expected root span %d, got %d", tc.size, span)
+			}
+			for addr, chunk := range cs {
+				if !bytes.Equal([]byte(addr), bmt.ChunkAddress(chunk)) {
+					t.Fatalf("expected chunk address %x, got %x", bmt.ChunkAddress(chunk), addr)
+				}
+			}
+			if tc.size > bmt.ChunkSize {
+				if len(root) > bmt.SpanSize+bmt.ChunkSize || (len(root)-bmt.SpanSize)%bmt.RefSize != 0 {
+					t.Fatalf("unexpected intermediate chunk size %d", len(root))
+				}
+			}
+		})
+	}
+}
+
+func TestSaverManifest(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	if err := n.Add(ctx, []byte("index.html"), bmt.Reference([]byte("<html></html>")), nil, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+	cs := chunkStore{}
+	if err := n.Save(ctx, bmt.NewSaver(cs)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// nodes are loaded from the chunk payloads
+	l := loaderFunc(func(_ context.Context, ref []byte) ([]byte, error) {
+		chunk, ok := cs[string(ref)]
+		if !ok {
+			return nil, mantaray.ErrNotFound
+		}
+		return chunk[bmt.SpanSize:], nil
+	})
+	e, err := mantaray.NewNodeRef(n.Reference()).Lookup(ctx, []byte("index.html"), l)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(e, bmt.Reference([]byte("<html></html>"))) {
+		t.Fatalf("unexpected entry %x", e)
+	}
+}
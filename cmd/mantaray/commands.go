@@ -0,0 +1,417 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ethersphere/manifest/fsstore"
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+var errCorrupt = errors.New("corrupt node")
+
+// cli holds the state shared by all commands.
+type cli struct {
+	ls   mantaray.LoadSaver
+	out  io.Writer
+	json bool
+}
+
+type command struct {
+	name    string
+	args    string
+	usage   string
+	minArgs int
+	maxArgs int // -1 for no limit
+	run     func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = []command{
+	{"init", "", "create an empty manifest", 0, 0, runInit},
+	{"ls", "<root> [prefix]", "list files and directories", 1, 2, runLs},
+	{"tree", "<root>", "print the node tree", 1, 1, runTree},
+	{"get", "<root> <path>", "print the entry and metadata of a path", 2, 2, runGet},
+	{"add", "<root> <path> <entry> [key=value...]", "add an entry", 3, -1, runAdd},
+	{"rm", "<root> <path>", "remove an entry", 2, 2, runRm},
+	{"mv", "<root> <from> <to>", "move an entry", 3, 3, runMv},
+	{"meta", "<root> <path> [key=value...]", "print or set metadata, an empty value removes the key", 2, -1, runMeta},
+	{"diff", "<root> <root>", "list the paths that differ", 2, 2, runDiff},
+	{"stat", "<root>", "print node and file counts", 1, 1, runStat},
+	{"verify", "<root>", "check that every node matches its reference and is well formed", 1, 1, runVerify},
+}
+
+func runInit(ctx context.Context, c *cli, _ []string) error {
+	return c.save(ctx, mantaray.New())
+}
+
+type lsEntry struct {
+	Path string `json:"path"`
+	Dir  bool   `json:"dir"`
+}
+
+func runLs(ctx context.Context, c *cli, args []string) error {
+	root, err := parseRoot(args[0])
+	if err != nil {
+		return err
+	}
+	var prefix []byte
+	if len(args) > 1 {
+		prefix = []byte(args[1])
+	}
+	entries := []lsEntry{}
+	err = root.Walk(ctx, prefix, c.ls, func(path []byte, isDir bool, err error) error {
+		if err != nil {
+			return err
+		}
+		if len(path) > 0 {
+			entries = append(entries, lsEntry{string(path), isDir})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.print(entries, func(w io.Writer) {
+		for _, e := range entries {
+			if e.Dir {
+				fmt.Fprintf(w, "%s/\n", e.Path)
+			} else {
+				fmt.Fprintln(w, e.Path)
+			}
+		}
+	})
+}
+
+func runTree(ctx context.Context, c *cli, args []string) error {
+	root, err := parseRoot(args[0])
+	if err != nil {
+		return err
+	}
+	// load every node so that the whole tree is printed
+	err = root.WalkNode(ctx, []byte{}, c.ls, func(_ []byte, _ *mantaray.Node, err error) error {
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	tree := root.String()
+	return c.print(map[string]string{"tree": tree}, func(w io.Writer) {
+		fmt.Fprint(w, tree)
+	})
+}
+
+type entryResult struct {
+	Path     string            `json:"path"`
+	Entry    string            `json:"entry"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func runGet(ctx context.Context, c *cli, args []string) error {
+	root, err := parseRoot(args[0])
+	if err != nil {
+		return err
+	}
+	n, err := lookupValue(ctx, root, args[1], c.ls)
+	if err != nil {
+		return err
+	}
+	r := entryResult{
+		Path:     args[1],
+		Entry:    hex.EncodeToString(n.Entry()),
+		Metadata: n.Metadata(),
+	}
+	return c.print(r, func(w io.Writer) {
+		fmt.Fprintln(w, r.Entry)
+		printMetadata(w, r.Metadata)
+	})
+}
+
+func runAdd(ctx context.Context, c *cli, args []string) error {
+	root, err := parseRoot(args[0])
+	if err != nil {
+		return err
+	}
+	entry, err := hex.DecodeString(args[2])
+	if err != nil {
+		return fmt.Errorf("entry: %w", err)
+	}
+	metadata, err := parseMetadata(args[3:])
+	if err != nil {
+		return err
+	}
+	if err := root.Add(ctx, []byte(args[1]), entry, metadata, c.ls); err != nil {
+		return err
+	}
+	return c.save(ctx, root)
+}
+
+func runRm(ctx context.Context, c *cli, args []string) error {
+	root, err := parseRoot(args[0])
+	if err != nil {
+		return err
+	}
+	if err := root.Remove(ctx, []byte(args[1]), c.ls); err != nil {
+		return err
+	}
+	return c.save(ctx, root)
+}
+
+func runMv(ctx context.Context, c *cli, args []string) error {
+	root, err := parseRoot(args[0])
+	if err != nil {
+		return err
+	}
+	n, err := lookupValue(ctx, root, args[1], c.ls)
+	if err != nil {
+		return err
+	}
+	ops := []mantaray.Op{
+		{
+			Type:     mantaray.OpRemove,
+			Path:     []byte(args[1]),
+			Expected: &mantaray.Precondition{Entry: n.Entry()},
+		},
+		{
+			Type:     mantaray.OpAdd,
+			Path:     []byte(args[2]),
+			Entry:    n.Entry(),
+			Metadata: n.Metadata(),
+			Expected: &mantaray.Precondition{Missing: true},
+		},
+	}
+	if err := root.Apply(ctx, &mantaray.Patch{Ops: ops}, c.ls); err != nil {
+		return err
+	}
+	return c.save(ctx, root)
+}
+
+func runMeta(ctx context.Context, c *cli, args []string) error {
+	root, err := parseRoot(args[0])
+	if err != nil {
+		return err
+	}
+	n, err := lookupValue(ctx, root, args[1], c.ls)
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		metadata := n.Metadata()
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		return c.print(metadata, func(w io.Writer) {
+			printMetadata(w, metadata)
+		})
+	}
+
+	changes, err := parseMetadata(args[2:])
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]string)
+	for k, v := range n.Metadata() {
+		metadata[k] = v
+	}
+	for k, v := range changes {
+		if v == "" {
+			delete(metadata, k)
+		} else {
+			metadata[k] = v
+		}
+	}
+	ops := []mantaray.Op{{Type: mantaray.OpSetMetadata, Path: []byte(args[1]), Metadata: metadata}}
+	if err := root.Apply(ctx, &mantaray.Patch{Ops: ops}, c.ls); err != nil {
+		return err
+	}
+	return c.save(ctx, root)
+}
+
+type diffEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+var diffMarks = map[mantaray.DiffType]string{
+	mantaray.DiffAdded:    "+",
+	mantaray.DiffRemoved:  "-",
+	mantaray.DiffModified: "~",
+}
+
+func runDiff(ctx context.Context, c *cli, args []string) error {
+	a, err := parseRoot(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := parseRoot(args[1])
+	if err != nil {
+		return err
+	}
+	var marks []string
+	entries := []diffEntry{}
+	err = mantaray.Diff(ctx, a, b, c.ls, func(path []byte, t mantaray.DiffType, _, _ *mantaray.Node) error {
+		entries = append(entries, diffEntry{string(path), t.String()})
+		marks = append(marks, diffMarks[t])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.print(entries, func(w io.Writer) {
+		for i, e := range entries {
+			fmt.Fprintf(w, "%s %s\n", marks[i], e.Path)
+		}
+	})
+}
+
+type statResult struct {
+	Nodes int `json:"nodes"`
+	Files int `json:"files"`
+	Bytes int `json:"bytes"`
+}
+
+func runStat(ctx context.Context, c *cli, args []string) error {
+	root, err := parseRoot(args[0])
+	if err != nil {
+		return err
+	}
+	var r statResult
+	l := loaderFunc(func(ctx context.Context, ref []byte) ([]byte, error) {
+		b, err := c.ls.Load(ctx, ref)
+		r.Bytes += len(b)
+		return b, err
+	})
+	err = root.WalkNode(ctx, []byte{}, l, func(_ []byte, n *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		r.Nodes++
+		if n.IsValueType() {
+			r.Files++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.print(r, func(w io.Writer) {
+		fmt.Fprintf(w, "nodes: %d\nfiles: %d\nbytes: %d\n", r.Nodes, r.Files, r.Bytes)
+	})
+}
+
+type verifyResult struct {
+	Nodes      int      `json:"nodes"`
+	OK         bool     `json:"ok"`
+	Violations []string `json:"violations,omitempty"`
+}
+
+func runVerify(ctx context.Context, c *cli, args []string) error {
+	root, err := parseRoot(args[0])
+	if err != nil {
+		return err
+	}
+	report, err := mantaray.Verify(ctx, root, c.ls, fsstore.Hash)
+	if err != nil {
+		return err
+	}
+	r := verifyResult{Nodes: report.Nodes, OK: report.OK()}
+	for _, v := range report.Violations {
+		r.Violations = append(r.Violations, v.String())
+	}
+	err = c.print(r, func(w io.Writer) {
+		for _, v := range r.Violations {
+			fmt.Fprintln(w, v)
+		}
+		if r.OK {
+			fmt.Fprintf(w, "ok: %d nodes\n", r.Nodes)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !r.OK {
+		return fmt.Errorf("%d violations: %w", len(r.Violations), errCorrupt)
+	}
+	return nil
+}
+
+type loaderFunc func(ctx context.Context, ref []byte) ([]byte, error)
+
+func (f loaderFunc) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	return f(ctx, ref)
+}
+
+// save saves the manifest rooted at n and prints its reference.
+func (c *cli) save(ctx context.Context, n *mantaray.Node) error {
+	if err := n.Save(ctx, c.ls); err != nil {
+		return err
+	}
+	ref := hex.EncodeToString(n.Reference())
+	return c.print(map[string]string{"root": ref}, func(w io.Writer) {
+		fmt.Fprintln(w, ref)
+	})
+}
+
+// print writes v as JSON if requested and calls text otherwise.
+func (c *cli) print(v interface{}, text func(w io.Writer)) error {
+	if c.json {
+		return json.NewEncoder(c.out).Encode(v)
+	}
+	text(c.out)
+	return nil
+}
+
+func parseRoot(s string) (*mantaray.Node, error) {
+	ref, err := hex.DecodeString(s)
+	if err != nil || len(ref) == 0 {
+		return nil, fmt.Errorf("invalid root reference %q", s)
+	}
+	return mantaray.NewNodeRef(ref), nil
+}
+
+func parseMetadata(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	metadata := make(map[string]string, len(args))
+	for _, a := range args {
+		i := strings.IndexByte(a, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid metadata %q, expected key=value", a)
+		}
+		metadata[a[:i]] = a[i+1:]
+	}
+	return metadata, nil
+}
+
+// lookupValue returns the node holding the entry at path.
+func lookupValue(ctx context.Context, root *mantaray.Node, path string, l mantaray.Loader) (*mantaray.Node, error) {
+	n, err := root.LookupNode(ctx, []byte(path), l)
+	if err != nil {
+		return nil, err
+	}
+	if !n.IsValueType() {
+		return nil, fmt.Errorf("path '%s': %w", path, mantaray.ErrNotFound)
+	}
+	return n, nil
+}
+
+func printMetadata(w io.Writer, metadata map[string]string) {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s=%s\n", k, metadata[k])
+	}
+}
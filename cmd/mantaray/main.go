@@ -0,0 +1,85 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command mantaray inspects and edits mantaray manifests kept in a local
+// store.
+//
+// Usage:
+//
+//	mantaray [-store dir] [-json] <command> [arguments]
+//
+// References and entries are hex encoded. Every command that changes a
+// manifest saves it and prints the reference of the new root.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethersphere/manifest/fsstore"
+)
+
+const defaultStore = ".mantaray"
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "mantaray: %v\n", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// run executes the command line args, writing results to stdout and usage
+// information to stderr.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("mantaray", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	storeDir := fs.String("store", storeFromEnv(), "directory of the local store")
+	jsonOutput := fs.Bool("json", false, "print results as JSON")
+	sync := fs.Bool("sync", false, "flush saved nodes to stable storage")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: mantaray [flags] <command> [arguments]\n\nCommands:\n")
+		for _, c := range commands {
+			fmt.Fprintf(stderr, "  %-7s %s\n", c.name, c.usage)
+		}
+		fmt.Fprintf(stderr, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	name, args := fs.Arg(0), fs.Args()[1:]
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if len(args) < c.minArgs || (c.maxArgs >= 0 && len(args) > c.maxArgs) {
+			return fmt.Errorf("usage: mantaray %s %s", c.name, c.args)
+		}
+		s, err := fsstore.New(*storeDir, &fsstore.Options{Sync: *sync})
+		if err != nil {
+			return err
+		}
+		return c.run(ctx, &cli{ls: s, out: stdout, json: *jsonOutput}, args)
+	}
+	return fmt.Errorf("unknown command %q", name)
+}
+
+func storeFromEnv() string {
+	if dir := strings.TrimSpace(os.Getenv("MANTARAY_STORE")); dir != "" {
+		return dir
+	}
+	return defaultStore
+}
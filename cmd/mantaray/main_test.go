@@ -0,0 +1,116 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+type testCLI struct {
+	t     *testing.T
+	store string
+}
+
+func (c *testCLI) run(args ...string) (string, error) {
+	c.t.Helper()
+	out := &bytes.Buffer{}
+	err := run(context.Background(), append([]string{"-store", c.store}, args...), out, ioutil.Discard)
+	return out.String(), err
+}
+
+func (c *testCLI) mustRun(args ...string) string {
+	c.t.Helper()
+	out, err := c.run(args...)
+	if err != nil {
+		c.t.Fatalf("%v: expected no error, got %v", args, err)
+	}
+	return strings.TrimSpace(out)
+}
+
+func TestCLI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mantaray")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &testCLI{t: t, store: dir}
+
+	entry := strings.Repeat("ab", 32)
+	root := c.mustRun("init")
+	root = c.mustRun("add", root, "index.html", entry, "Content-Type=text/html")
+	root = c.mustRun("add", root, "img/logo.png", entry)
+
+	if out := c.mustRun("ls", root); out != "img/\nimg/logo.png\nindex.html" {
+		t.Fatalf("unexpected listing %q", out)
+	}
+
+	var got entryResult
+	if err := json.Unmarshal([]byte(c.mustRun("-json", "get", root, "index.html")), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Entry != entry || got.Metadata["Content-Type"] != "text/html" {
+		t.Fatalf("unexpected entry %+v", got)
+	}
+
+	moved := c.mustRun("mv", root, "img/logo.png", "logo.png")
+	if out := c.mustRun("diff", root, moved); out != "- img/logo.png\n+ logo.png" {
+		t.Fatalf("unexpected diff %q", out)
+	}
+
+	edited := c.mustRun("meta", moved, "index.html", "Content-Type=", "Cache-Control=no-cache")
+	if out := c.mustRun("meta", edited, "index.html"); out != "Cache-Control=no-cache" {
+		t.Fatalf("unexpected metadata %q", out)
+	}
+
+	removed := c.mustRun("rm", edited, "logo.png")
+	if _, err := c.run("get", removed, "logo.png"); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	var st statResult
+	if err := json.Unmarshal([]byte(c.mustRun("-json", "stat", removed)), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Files != 1 {
+		t.Fatalf("expected 1 file, got %d", st.Files)
+	}
+	if !strings.Contains(c.mustRun("tree", removed), "ndex.html") {
+		t.Fatal("expected tree to show index.html")
+	}
+
+	c.mustRun("verify", removed)
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(f, []byte("corrupt"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.run("verify", removed); !errors.Is(err, errCorrupt) {
+		t.Fatalf("expected corrupt node error, got %v", err)
+	}
+}
+
+func TestCLIUsage(t *testing.T) {
+	c := &testCLI{t: t, store: t.Name()}
+	if _, err := c.run("nope"); err == nil {
+		t.Fatal("expected error for unknown command")
+	}
+	if _, err := c.run("add", "00"); err == nil {
+		t.Fatal("expected error for missing arguments")
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package exporter writes the files of mantaray manifests to local storage.
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethersphere/manifest/importer"
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+// ErrUnsafePath is returned for manifest paths, or existing files in the
+// export directory, that would have files written outside of it.
+var ErrUnsafePath = errors.New("unsafe path")
+
+// defaultMode is the permission of exported files without mode metadata.
+const defaultMode = 0644
+
+// epoch is the modification time of exported entries without one.
+var epoch = time.Unix(0, 0)
+
+// Export writes every file of the manifest rooted at root under dir,
+// creating directories as needed. Manifest nodes are loaded through l and
+// file contents through content. Mode and modification time metadata set
+// by the importer are restored when present.
+//
+// Files are written to a temporary file and renamed into place. An
+// interrupted export is resumed by calling Export again, which keeps the
+// files whose content already matches their entry and rewrites the others.
+// Directories are never followed through symbolic links, so a resumed
+// export does not write outside of dir.
+func Export(ctx context.Context, root *mantaray.Node, dir string, l, content mantaray.Loader) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return root.WalkNode(ctx, []byte{}, l, func(path []byte, n *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if len(path) == 0 || !n.IsValueType() {
+			// intermediate directories are created with the files
+			return nil
+		}
+		isDir := path[len(path)-1] == mantaray.PathSeparator
+		if isDir {
+			// the entry on '/' holds the metadata of the root
+			if path = path[:len(path)-1]; len(path) == 0 {
+				return nil
+			}
+		}
+		target, err := localPath(dir, string(path))
+		if err != nil {
+			return err
+		}
+		if isDir {
+			return makeDirs(dir, target)
+		}
+		return exportFile(ctx, dir, target, n, content)
+	})
+}
+
+// localPath returns the location of the manifest path p under dir.
+func localPath(dir, p string) (string, error) {
+	if err := checkPath(p); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.FromSlash(p)), nil
+}
+
+// checkPath fails with ErrUnsafePath if p is absolute or contains a parent
+// directory element.
+func checkPath(p string) error {
+	if p == "" || strings.HasPrefix(p, "/") || strings.ContainsAny(p, "\\\x00") || filepath.IsAbs(p) {
+		return fmt.Errorf("path '%s': %w", p, ErrUnsafePath)
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == ".." {
+			return fmt.Errorf("path '%s': %w", p, ErrUnsafePath)
+		}
+	}
+	return nil
+}
+
+func exportFile(ctx context.Context, dir, target string, n *mantaray.Node, content mantaray.Loader) error {
+	data, err := content.Load(ctx, n.Entry())
+	if err != nil {
+		return err
+	}
+	if ok, err := sameContent(target, data); err != nil {
+		return err
+	} else if ok {
+		// written by a previous export
+		return nil
+	}
+
+	mode, err := fileMode(n)
+	if err != nil {
+		return fmt.Errorf("mode of '%s': %w", target, err)
+	}
+
+	if err := makeDirs(dir, filepath.Dir(target)); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	// a renamed file must be complete even if the system crashes
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	if _, ok := n.Metadata()[importer.ModTimeKey]; ok {
+		t, err := modTime(n)
+		if err != nil {
+			return fmt.Errorf("modification time of '%s': %w", target, err)
+		}
+		if err := os.Chtimes(tmp, t, t); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp, target)
+}
+
+// sameContent reports whether target is a regular file holding data.
+func sameContent(target string, data []byte) (bool, error) {
+	fi, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !fi.Mode().IsRegular() || fi.Size() != int64(len(data)) {
+		return false, nil
+	}
+	b, err := ioutil.ReadFile(target)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(b, data), nil
+}
+
+// makeDirs creates target and the directories above it up to dir. Unlike
+// os.MkdirAll, it fails with ErrUnsafePath if any of them exists but is
+// not a directory, such as a symbolic link left in dir.
+func makeDirs(dir, target string) error {
+	rel, err := filepath.Rel(dir, target)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	p := dir
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(p, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !fi.IsDir():
+			return fmt.Errorf("'%s' is not a directory: %w", p, ErrUnsafePath)
+		}
+	}
+	return nil
+}
+
+// fileMode returns the permission bits recorded on n or the default ones.
+func fileMode(n *mantaray.Node) (os.FileMode, error) {
+	v, ok := n.Metadata()[importer.ModeKey]
+	if !ok {
+		return defaultMode, nil
+	}
+	return importer.ParseMode(v)
+}
+
+// modTime returns the modification time recorded on n or the Unix epoch.
+func modTime(n *mantaray.Node) (time.Time, error) {
+	v, ok := n.Metadata()[importer.ModTimeKey]
+	if !ok {
+		return epoch, nil
+	}
+	return importer.ParseModTime(v)
+}
@@ -0,0 +1,223 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exporter_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/manifest/exporter"
+	"github.com/ethersphere/manifest/importer"
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+type mockLoadSaver struct {
+	mtx   sync.Mutex
+	store map[string][]byte
+	loads int
+}
+
+func newMockLoadSaver() *mockLoadSaver {
+	return &mockLoadSaver{
+		store: make(map[string][]byte),
+	}
+}
+
+func (m *mockLoadSaver) Save(_ context.Context, b []byte) ([]byte, error) {
+	h := sha256.Sum256(b)
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.store[string(h[:])] = b
+	return h[:], nil
+}
+
+func (m *mockLoadSaver) Load(_ context.Context, ref []byte) ([]byte, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.loads++
+	b, ok := m.store[string(ref)]
+	if !ok {
+		return nil, mantaray.ErrNotFound
+	}
+	return b, nil
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestExport(t *testing.T) {
+	src := tempDir(t)
+	defer os.RemoveAll(src)
+	dst := tempDir(t)
+	defer os.RemoveAll(dst)
+
+	files := map[string]string{
+		"index.html":        "<html></html>",
+		"img/logo.png":      "\x89PNG\r\n\x1a\n",
+		"bin/run.sh":        "#!/bin/sh",
+		"a/b/c/deep.txt":    "deep",
+		"img/icons/x.svg":   "<svg/>",
+		"img/icons/y.svg":   "<svg></svg>",
+		"img/icons/z/.keep": "",
+	}
+	mtime := time.Unix(1600000000, 0)
+	for name, data := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(src, "bin", "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	content := newMockLoadSaver()
+	ls := newMockLoadSaver()
+	ref, err := importer.Import(ctx, src, content, ls, &importer.Options{IndexDocument: "index.html"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := exporter.Export(ctx, mantaray.NewNodeRef(ref), dst, ls, content); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for name, data := range files {
+		p := filepath.Join(dst, filepath.FromSlash(name))
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatalf("expected %s to be exported, got %v", name, err)
+		}
+		if string(b) != data {
+			t.Fatalf("expected content %q of %s, got %q", data, name, b)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Fatalf("expected modification time %s of %s, got %s", mtime, name, fi.ModTime())
+		}
+	}
+	fi, err := os.Stat(filepath.Join(dst, "bin", "run.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0755 {
+		t.Fatalf("expected mode 0755, got %v", fi.Mode().Perm())
+	}
+
+	// resuming rewrites the missing and truncated files only
+	if err := os.Remove(filepath.Join(dst, "index.html")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dst, "img", "logo.png"), []byte("\x89PNG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kept := filepath.Join(dst, "a", "b", "c", "deep.txt")
+	before, err := os.Stat(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Export(ctx, mantaray.NewNodeRef(ref), dst, ls, content); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, name := range []string{"index.html", "img/logo.png"} {
+		if b, err := ioutil.ReadFile(filepath.Join(dst, filepath.FromSlash(name))); err != nil || string(b) != files[name] {
+			t.Fatalf("expected %s to be restored, got %q, %v", name, b, err)
+		}
+	}
+	after, err := os.Stat(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Fatalf("expected complete file %s to be kept", kept)
+	}
+}
+
+func TestExportSymlink(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	outside := filepath.Join(dir, "outside")
+	dst := filepath.Join(dir, "out")
+	for _, d := range []string{outside, dst} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a directory replaced by a link between two runs of an export
+	if err := os.Symlink(outside, filepath.Join(dst, "img")); err != nil {
+		t.Skipf("symbolic links not supported: %v", err)
+	}
+
+	ctx := context.Background()
+	content := newMockLoadSaver()
+	entry, err := content.Save(ctx, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := mantaray.New()
+	if err := n.Add(ctx, []byte("img/logo.png"), entry, nil, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = exporter.Export(ctx, n, dst, nil, content)
+	if !errors.Is(err, exporter.ErrUnsafePath) {
+		t.Fatalf("expected unsafe path error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "logo.png")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file outside the export directory, got %v", err)
+	}
+}
+
+func TestExportUnsafePath(t *testing.T) {
+	ctx := context.Background()
+	for _, p := range []string{
+		"../escape.txt",
+		"a/../../escape.txt",
+		"/etc/passwd",
+	} {
+		t.Run(p, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			content := newMockLoadSaver()
+			entry, err := content.Save(ctx, []byte("data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := mantaray.New()
+			if err := n.Add(ctx, []byte(p), entry, nil, nil); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			err = exporter.Export(ctx, n, filepath.Join(dir, "out"), nil, content)
+			if !errors.Is(err, exporter.ErrUnsafePath) {
+				t.Fatalf("expected unsafe path error, got %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "escape.txt")); !os.IsNotExist(err) {
+				t.Fatalf("expected no file outside the export directory, got %v", err)
+			}
+		})
+	}
+}
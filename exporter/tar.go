@@ -0,0 +1,84 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exporter
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+// ExportTar writes every file of the manifest rooted at root to w as a tar
+// archive. Manifest nodes are loaded through l and file contents through
+// content. Entries are written in lexicographic order with fixed ownership,
+// so the same manifest always produces the same archive. Directories and
+// files without modification time metadata carry the Unix epoch.
+func ExportTar(ctx context.Context, root *mantaray.Node, w io.Writer, l, content mantaray.Loader) error {
+	tw := tar.NewWriter(w)
+	err := root.Walk(ctx, []byte{}, l, func(path []byte, isDir bool, err error) error {
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if len(path) == 0 {
+			return nil
+		}
+		name := string(path)
+		if err := checkPath(name); err != nil {
+			return err
+		}
+		if isDir {
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     name + "/",
+				Mode:     0755,
+				ModTime:  epoch,
+			})
+		}
+		n, err := root.LookupNode(ctx, path, l)
+		if err != nil {
+			return err
+		}
+		return writeTarFile(ctx, tw, name, n, content)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeTarFile(ctx context.Context, tw *tar.Writer, name string, n *mantaray.Node, content mantaray.Loader) error {
+	data, err := content.Load(ctx, n.Entry())
+	if err != nil {
+		return err
+	}
+	mode, err := fileMode(n)
+	if err != nil {
+		return fmt.Errorf("mode of '%s': %w", name, err)
+	}
+	t, err := modTime(n)
+	if err != nil {
+		return fmt.Errorf("modification time of '%s': %w", name, err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode),
+		Size:     int64(len(data)),
+		ModTime:  t,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exporter_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	"github.com/ethersphere/manifest/exporter"
+	"github.com/ethersphere/manifest/importer"
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func TestExportTar(t *testing.T) {
+	ctx := context.Background()
+	content := newMockLoadSaver()
+	ls := newMockLoadSaver()
+
+	files := map[string]string{}
+	n := mantaray.New()
+	for i := 0; i < 50; i++ {
+		p := fmt.Sprintf("dir%d/file%02d.txt", i%3, i)
+		files[p] = fmt.Sprintf("content %d", i)
+		entry, err := content.Save(ctx, []byte(files[p]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		md := map[string]string{importer.ModeKey: "0640", importer.ModTimeKey: "1600000000"}
+		if err := n.Add(ctx, []byte(p), entry, md, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	a := &bytes.Buffer{}
+	if err := exporter.ExportTar(ctx, mantaray.NewNodeRef(n.Reference()), a, ls, content); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	b := &bytes.Buffer{}
+	if err := exporter.ExportTar(ctx, mantaray.NewNodeRef(n.Reference()), b, ls, content); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatal("expected reproducible archives")
+	}
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(a.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != files[hdr.Name] {
+			t.Fatalf("expected content %q of %s, got %q", files[hdr.Name], hdr.Name, data)
+		}
+		if hdr.Mode != 0640 || hdr.ModTime.Unix() != 1600000000 {
+			t.Fatalf("expected mode and modification time of %s, got %o %s", hdr.Name, hdr.Mode, hdr.ModTime)
+		}
+	}
+	if len(names) != len(files)+3 {
+		t.Fatalf("expected %d entries, got %d", len(files)+3, len(names))
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Fatalf("expected entries in lexicographic order, got %v", names)
+	}
+
+	// importing the archive gives back the same manifest
+	ref, err := importer.ImportTar(ctx, bytes.NewReader(a.Bytes()), content, ls, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = mantaray.Diff(ctx, mantaray.NewNodeRef(n.Reference()), mantaray.NewNodeRef(ref), ls, func(path []byte, typ mantaray.DiffType, _, _ *mantaray.Node) error {
+		if typ != mantaray.DiffModified {
+			t.Errorf("unexpected %s difference on %s", typ, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fsstore provides a content addressed mantaray.LoadSaver keeping
+// node blobs in a local directory.
+package fsstore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ethersphere/manifest/mantaray"
+	"golang.org/x/crypto/sha3"
+)
+
+// RefSize is the size of the references returned by the store.
+const RefSize = 32
+
+// ErrInvalidReference is returned for references of the wrong size.
+var ErrInvalidReference = errors.New("invalid reference")
+
+// Options configure a store.
+type Options struct {
+	// Sync, if set, flushes every blob and its directory to stable storage
+	// before Save returns.
+	Sync bool
+}
+
+// Store keeps blobs under dir, each in a file named by the hex encoded
+// keccak256 hash of its content inside a directory named by the first byte
+// of the hash.
+type Store struct {
+	dir  string
+	sync bool
+}
+
+// New returns a store keeping blobs under dir, creating it if needed.
+func New(dir string, o *Options) (*Store, error) {
+	if o == nil {
+		o = &Options{}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{
+		dir:  dir,
+		sync: o.Sync,
+	}, nil
+}
+
+// Hash returns the reference of b.
+func Hash(b []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	_, _ = h.Write(b)
+	return h.Sum(nil)
+}
+
+// Save implements mantaray.Saver. The blob is written to a temporary file
+// that is renamed into place, so a stored blob is always complete.
+func (s *Store) Save(ctx context.Context, b []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ref := Hash(b)
+	shard, p := s.path(ref)
+	if _, err := os.Stat(p); err == nil {
+		return ref, nil
+	}
+	if err := os.MkdirAll(shard, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile(shard, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if s.sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return nil, err
+	}
+	if s.sync {
+		if err := syncDir(shard); err != nil {
+			return nil, err
+		}
+	}
+	return ref, nil
+}
+
+// Load implements mantaray.Loader. It returns an error wrapping
+// mantaray.ErrNotFound if no blob is stored under ref.
+func (s *Store) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(ref) != RefSize {
+		return nil, fmt.Errorf("reference %x: %w", ref, ErrInvalidReference)
+	}
+	_, p := s.path(ref)
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("reference %x: %w", ref, mantaray.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// path returns the shard directory and the file of ref.
+func (s *Store) path(ref []byte) (string, string) {
+	name := hex.EncodeToString(ref)
+	shard := filepath.Join(s.dir, name[:2])
+	return shard, filepath.Join(shard, name)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsstore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethersphere/manifest/fsstore"
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func newStore(t *testing.T, o *fsstore.Options) (*fsstore.Store, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "fsstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := fsstore.New(dir, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func TestStore(t *testing.T) {
+	for _, o := range []*fsstore.Options{nil, {Sync: true}} {
+		t.Run(fmt.Sprintf("%+v", o), func(t *testing.T) {
+			s, dir := newStore(t, o)
+			defer os.RemoveAll(dir)
+			ctx := context.Background()
+
+			data := []byte("hello world")
+			ref, err := s.Save(ctx, data)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			// keccak256("hello world")
+			if hex.EncodeToString(ref) != "47173285a8d7341e5e972fc677286384f802f8ef42a5ec5f03bbfa254cb01fad" {
+				t.Fatalf("unexpected reference %x", ref)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "47", hex.EncodeToString(ref))); err != nil {
+				t.Fatalf("expected sharded file, got %v", err)
+			}
+			if again, err := s.Save(ctx, data); err != nil || !bytes.Equal(again, ref) {
+				t.Fatalf("expected same reference, got %x, %v", again, err)
+			}
+
+			b, err := s.Load(ctx, ref)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !bytes.Equal(b, data) {
+				t.Fatalf("expected %q, got %q", data, b)
+			}
+
+			tmp, err := filepath.Glob(filepath.Join(dir, "*", ".tmp-*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tmp) != 0 {
+				t.Fatalf("expected no temporary files, got %v", tmp)
+			}
+		})
+	}
+}
+
+func TestStoreNotFound(t *testing.T) {
+	s, dir := newStore(t, nil)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	missing := fsstore.Hash([]byte("missing"))
+	if _, err := s.Load(ctx, missing); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if _, err := s.Load(ctx, []byte{1, 2, 3}); !errors.Is(err, fsstore.ErrInvalidReference) {
+		t.Fatalf("expected invalid reference error, got %v", err)
+	}
+	n := mantaray.NewNodeRef(missing)
+	if _, err := n.Lookup(ctx, []byte("a"), s); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error on load, got %v", err)
+	}
+}
+
+func TestStoreManifest(t *testing.T) {
+	s, dir := newStore(t, nil)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	n := mantaray.New()
+	for i := 0; i < 20; i++ {
+		p := fmt.Sprintf("dir/file%d", i)
+		if err := n.Add(ctx, []byte(p), fsstore.Hash([]byte(p)), nil, s); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, s); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	m := mantaray.NewNodeRef(n.Reference())
+	for i := 0; i < 20; i++ {
+		p := fmt.Sprintf("dir/file%d", i)
+		e, err := m.Lookup(ctx, []byte(p), s)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(e, fsstore.Hash([]byte(p))) {
+			t.Fatalf("unexpected entry %x of %s", e, p)
+		}
+	}
+}
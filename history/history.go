@@ -0,0 +1,252 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package history records successive roots of a mantaray manifest as a
+// chain of commits persisted next to the manifest nodes.
+package history
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+const commitVersion = "mantaray-history:0.1"
+
+var (
+	// ErrNoCommit is returned when no commit matches the request.
+	ErrNoCommit = errors.New("no commit")
+	// ErrInvalidCommit is returned when a loaded commit cannot be decoded.
+	ErrInvalidCommit = errors.New("invalid commit")
+)
+
+// Commit is a single saved manifest root and its predecessor.
+type Commit struct {
+	// Parent is the reference of the previous commit, nil for the first one.
+	Parent []byte
+	// ParentRoot is the manifest root of the previous commit.
+	ParentRoot []byte
+	// Root is the manifest root recorded by the commit.
+	Root      []byte
+	Message   string
+	Timestamp time.Time
+}
+
+// commit is a JSON representation of a commit.
+type commit struct {
+	Version    string `json:"version"`
+	Parent     string `json:"parent,omitempty"`
+	ParentRoot string `json:"parentRoot,omitempty"`
+	Root       string `json:"root"`
+	Message    string `json:"message,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (c *Commit) MarshalBinary() ([]byte, error) {
+	return json.Marshal(&commit{
+		Version:    commitVersion,
+		Parent:     hex.EncodeToString(c.Parent),
+		ParentRoot: hex.EncodeToString(c.ParentRoot),
+		Root:       hex.EncodeToString(c.Root),
+		Message:    c.Message,
+		Timestamp:  c.Timestamp.UnixNano(),
+	})
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (c *Commit) UnmarshalBinary(b []byte) error {
+	var cj commit
+	if err := json.Unmarshal(b, &cj); err != nil {
+		return fmt.Errorf("%v: %w", err, ErrInvalidCommit)
+	}
+	if cj.Version != commitVersion {
+		return fmt.Errorf("unsupported version %q: %w", cj.Version, ErrInvalidCommit)
+	}
+	var err error
+	if c.Parent, err = decodeHex(cj.Parent); err != nil {
+		return err
+	}
+	if c.ParentRoot, err = decodeHex(cj.ParentRoot); err != nil {
+		return err
+	}
+	if c.Root, err = decodeHex(cj.Root); err != nil {
+		return err
+	}
+	c.Message = cj.Message
+	c.Timestamp = time.Unix(0, cj.Timestamp)
+	return nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrInvalidCommit)
+	}
+	return b, nil
+}
+
+// History is a chain of commits ending at head.
+type History struct {
+	ls   mantaray.LoadSaver
+	head []byte
+	now  func() time.Time
+	o    *mantaray.SaveOptions
+}
+
+// New returns the history ending at the commit referenced by head. A nil
+// head starts a new history.
+func New(ls mantaray.LoadSaver, head []byte) *History {
+	return &History{
+		ls:   ls,
+		head: head,
+		now:  time.Now,
+	}
+}
+
+// SetSaveOptions sets the options used to save the manifest on Commit,
+// such as its maximum node size.
+func (h *History) SetSaveOptions(o *mantaray.SaveOptions) {
+	h.o = o
+}
+
+// Head returns the reference of the latest commit.
+func (h *History) Head() []byte {
+	return h.head
+}
+
+// Get loads the commit referenced by ref.
+func (h *History) Get(ctx context.Context, ref []byte) (*Commit, error) {
+	b, err := h.ls.Load(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	c := &Commit{}
+	if err := c.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Commit saves the manifest rooted at root and records it as the new head.
+func (h *History) Commit(ctx context.Context, root *mantaray.Node, message string) ([]byte, error) {
+	if err := root.SaveWithOptions(ctx, h.ls, h.o); err != nil {
+		return nil, err
+	}
+	c := &Commit{
+		Parent:    h.head,
+		Root:      root.Reference(),
+		Message:   message,
+		Timestamp: h.now(),
+	}
+	if h.head != nil {
+		parent, err := h.Get(ctx, h.head)
+		if err != nil {
+			return nil, err
+		}
+		c.ParentRoot = parent.Root
+	}
+	b, err := c.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	ref, err := h.ls.Save(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	h.head = ref
+	return ref, nil
+}
+
+// Change is a single entry changed by a commit.
+type Change struct {
+	Path []byte
+	Type mantaray.DiffType
+}
+
+// LogFunc is the type of the function called for each commit visited by
+// Log, newest first.
+type LogFunc func(ref []byte, c *Commit, changes []Change) error
+
+// Log calls fn for every commit from head to the first one, together with
+// the entries each commit changed compared to its parent.
+func (h *History) Log(ctx context.Context, fn LogFunc) error {
+	for ref := h.head; ref != nil; {
+		c, err := h.Get(ctx, ref)
+		if err != nil {
+			return err
+		}
+		var changes []Change
+		err = mantaray.Diff(ctx, h.node(c.ParentRoot), h.node(c.Root), h.ls, func(path []byte, t mantaray.DiffType, _, _ *mantaray.Node) error {
+			changes = append(changes, Change{Path: path, Type: t})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := fn(ref, c, changes); err != nil {
+			return err
+		}
+		ref = c.Parent
+	}
+	return nil
+}
+
+// Checkout returns the manifest root recorded by the latest commit made at
+// or before at.
+func (h *History) Checkout(ctx context.Context, at time.Time) (*mantaray.Node, error) {
+	for ref := h.head; ref != nil; {
+		c, err := h.Get(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if !c.Timestamp.After(at) {
+			return mantaray.NewNodeRef(c.Root), nil
+		}
+		ref = c.Parent
+	}
+	return nil, fmt.Errorf("at %s: %w", at, ErrNoCommit)
+}
+
+// Revert undoes the changes made by the commit referenced by ref on top of
+// the current head and records the result as a new commit. It fails with
+// mantaray.ErrPreconditionFailed if a later commit changed the same entries.
+func (h *History) Revert(ctx context.Context, ref []byte, message string) ([]byte, error) {
+	if h.head == nil {
+		return nil, ErrNoCommit
+	}
+	c, err := h.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	head, err := h.Get(ctx, h.head)
+	if err != nil {
+		return nil, err
+	}
+	p, err := mantaray.NewPatch(ctx, h.node(c.Root), h.node(c.ParentRoot), h.ls)
+	if err != nil {
+		return nil, err
+	}
+	root := mantaray.NewNodeRef(head.Root)
+	if err := root.Apply(ctx, p, h.ls); err != nil {
+		return nil, err
+	}
+	return h.Commit(ctx, root, message)
+}
+
+// node returns the manifest root referenced by ref or an empty one.
+func (h *History) node(ref []byte) *mantaray.Node {
+	if ref == nil {
+		return mantaray.New()
+	}
+	return mantaray.NewNodeRef(ref)
+}
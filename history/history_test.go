@@ -0,0 +1,150 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package history
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+type mockLoadSaver struct {
+	mtx   sync.Mutex
+	store map[string][]byte
+}
+
+func newMockLoadSaver() *mockLoadSaver {
+	return &mockLoadSaver{
+		store: make(map[string][]byte),
+	}
+}
+
+func (m *mockLoadSaver) Save(_ context.Context, b []byte) ([]byte, error) {
+	h := sha256.Sum256(b)
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.store[string(h[:])] = b
+	return h[:], nil
+}
+
+func (m *mockLoadSaver) Load(_ context.Context, ref []byte) ([]byte, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	b, ok := m.store[string(ref)]
+	if !ok {
+		return nil, mantaray.ErrNotFound
+	}
+	return b, nil
+}
+
+func entry(path string) []byte {
+	return append(make([]byte, 32-len(path)), path...)
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	h := New(ls, nil)
+	start := time.Unix(1600000000, 0)
+	tick := 0
+	h.now = func() time.Time {
+		tick++
+		return start.Add(time.Duration(tick) * time.Hour)
+	}
+
+	root := mantaray.New()
+	var commits [][]byte
+	for _, step := range [][]string{
+		{"index.html", "img/1.png"},
+		{"img/2.png"},
+		{"robots.txt"},
+	} {
+		for _, p := range step {
+			if err := root.Add(ctx, []byte(p), entry(p), nil, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		ref, err := h.Commit(ctx, root, fmt.Sprintf("add %v", step))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		commits = append(commits, ref)
+	}
+
+	t.Run("log", func(t *testing.T) {
+		var got []string
+		err := h.Log(ctx, func(ref []byte, c *Commit, changes []Change) error {
+			s := c.Message + ":"
+			for _, ch := range changes {
+				s += fmt.Sprintf(" %s %s", ch.Type, ch.Path)
+			}
+			got = append(got, s)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		exp := fmt.Sprint([]string{
+			"add [robots.txt]: added robots.txt",
+			"add [img/2.png]: added img/2.png",
+			"add [index.html img/1.png]: added img/1.png added index.html",
+		})
+		if fmt.Sprint(got) != exp {
+			t.Fatalf("expected %s, got %s", exp, got)
+		}
+	})
+
+	t.Run("checkout", func(t *testing.T) {
+		n, err := h.Checkout(ctx, start.Add(2*time.Hour+time.Minute))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		c, err := h.Get(ctx, commits[1])
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(n.Reference(), c.Root) {
+			t.Fatalf("expected root %x, got %x", c.Root, n.Reference())
+		}
+		if _, err := h.Checkout(ctx, start); !errors.Is(err, ErrNoCommit) {
+			t.Fatalf("expected no commit error, got %v", err)
+		}
+	})
+
+	t.Run("revert", func(t *testing.T) {
+		ref, err := h.Revert(ctx, commits[1], "revert img/2.png")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(h.Head(), ref) {
+			t.Fatalf("expected head %x, got %x", ref, h.Head())
+		}
+		c, err := h.Get(ctx, ref)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		n := mantaray.NewNodeRef(c.Root)
+		if _, err := n.Lookup(ctx, []byte("img/2.png"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("expected img/2.png to be removed, got %v", err)
+		}
+		for _, p := range []string{"index.html", "img/1.png", "robots.txt"} {
+			if _, err := n.Lookup(ctx, []byte(p), ls); err != nil {
+				t.Fatalf("expected %s to be kept, got %v", p, err)
+			}
+		}
+
+		// the reverted entry is gone, so reverting again conflicts
+		if _, err := h.Revert(ctx, commits[1], "again"); !errors.Is(err, mantaray.ErrPreconditionFailed) {
+			t.Fatalf("expected precondition error, got %v", err)
+		}
+	})
+}
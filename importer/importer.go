@@ -0,0 +1,248 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package importer builds mantaray manifests from local files.
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+// Metadata keys set on imported entries.
+const (
+	ContentTypeKey   = "Content-Type"
+	FilenameKey      = "Filename"
+	IndexDocumentKey = "index-document"
+	// ModeKey holds the octal permission bits of a file.
+	ModeKey = "Mode"
+	// ModTimeKey holds the modification time of a file in Unix seconds.
+	ModTimeKey = "Mtime"
+	// CommentKey holds the comment of an archive or one of its files.
+	CommentKey = "Comment"
+)
+
+// rootPath is the path holding the metadata of the whole manifest.
+const rootPath = "/"
+
+// Options configure an import.
+type Options struct {
+	// Ignore lists patterns of files and directories to skip. A pattern
+	// uses the path.Match syntax and is matched against both the slash
+	// separated path relative to the imported directory and the base name.
+	Ignore []string
+	// IndexDocument, if set, is recorded as the index-document metadata of
+	// the manifest root.
+	IndexDocument string
+	// Directories, if set, adds an explicit entry with a trailing slash for
+	// each directory listed in a zip archive instead of skipping it.
+	Directories bool
+	// MaxNodeSize is the maximum size of a saved manifest node, as in
+	// mantaray.SaveOptions.
+	MaxNodeSize int
+}
+
+// Import adds every regular file under dir to a new manifest and returns
+// the reference of its saved root. File contents are stored through
+// content and manifest nodes through s. Symbolic links and other special
+// files are skipped.
+func Import(ctx context.Context, dir string, content, s mantaray.Saver, o *Options) ([]byte, error) {
+	if o == nil {
+		o = &Options{}
+	}
+	if err := validatePatterns(o.Ignore); err != nil {
+		return nil, err
+	}
+
+	var files []file
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if ignored(o.Ignore, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, file{rel, info})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entry, 0, len(files)+1)
+	for _, f := range files {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		e, err := saveFile(ctx, filepath.Join(dir, filepath.FromSlash(f.path)), f.path, content)
+		if err != nil {
+			return nil, err
+		}
+		e.metadata[ModeKey] = FormatMode(f.info.Mode())
+		e.metadata[ModTimeKey] = FormatModTime(f.info.ModTime())
+		entries = append(entries, e)
+	}
+	return build(ctx, entries, rootMetadata(o), s, o)
+}
+
+type file struct {
+	path string
+	info os.FileInfo
+}
+
+// entry is a file whose content is already saved.
+type entry struct {
+	path     string
+	ref      []byte
+	metadata map[string]string
+}
+
+func saveFile(ctx context.Context, file, p string, content mantaray.Saver) (entry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return entry{}, err
+	}
+	return saveData(ctx, data, p, content)
+}
+
+func saveData(ctx context.Context, data []byte, p string, content mantaray.Saver) (entry, error) {
+	ref, err := content.Save(ctx, data)
+	if err != nil {
+		return entry{}, err
+	}
+	return entry{
+		path: p,
+		ref:  ref,
+		metadata: map[string]string{
+			ContentTypeKey: contentType(p, data),
+			FilenameKey:    path.Base(p),
+		},
+	}, nil
+}
+
+// build saves a manifest holding the entries and the root metadata.
+// Entries without a reference, such as directories, get a zero reference of
+// the size used by the files. When a path is given more than once, the last
+// entry wins.
+func build(ctx context.Context, entries []entry, root map[string]string, s mantaray.Saver, o *Options) ([]byte, error) {
+	if len(root) > 0 {
+		entries = append(entries, entry{path: rootPath, metadata: root})
+	}
+	size := 0
+	for _, e := range entries {
+		if e.ref != nil {
+			size = len(e.ref)
+			break
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+
+	b := mantaray.NewBuilder(s, &mantaray.BuilderOptions{MaxNodeSize: o.MaxNodeSize})
+	for i, e := range entries {
+		if i+1 < len(entries) && entries[i+1].path == e.path {
+			continue
+		}
+		ref := e.ref
+		if ref == nil {
+			ref = make([]byte, size)
+		}
+		if err := b.Add(ctx, []byte(e.path), ref, e.metadata); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish(ctx)
+}
+
+// rootMetadata returns the metadata of the manifest root set by o.
+func rootMetadata(o *Options) map[string]string {
+	md := make(map[string]string)
+	if o.IndexDocument != "" {
+		md[IndexDocumentKey] = o.IndexDocument
+	}
+	return md
+}
+
+// contentType returns the media type for the file extension, falling back
+// to sniffing the content.
+func contentType(name string, data []byte) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return http.DetectContentType(data)
+}
+
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ignored(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(p)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatMode formats the permission bits of mode as a ModeKey value.
+func FormatMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// ParseMode parses a ModeKey value.
+func ParseMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(m).Perm(), nil
+}
+
+// FormatModTime formats t as a ModTimeKey value.
+func FormatModTime(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// ParseModTime parses a ModTimeKey value.
+func ParseModTime(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethersphere/manifest/importer"
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+type mockLoadSaver struct {
+	mtx   sync.Mutex
+	store map[string][]byte
+}
+
+func newMockLoadSaver() *mockLoadSaver {
+	return &mockLoadSaver{
+		store: make(map[string][]byte),
+	}
+}
+
+func (m *mockLoadSaver) Save(_ context.Context, b []byte) ([]byte, error) {
+	h := sha256.Sum256(b)
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.store[string(h[:])] = b
+	return h[:], nil
+}
+
+func (m *mockLoadSaver) Load(_ context.Context, ref []byte) ([]byte, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	b, ok := m.store[string(ref)]
+	if !ok {
+		return nil, mantaray.ErrNotFound
+	}
+	return b, nil
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"index.html":        "<html></html>",
+		"img/logo.png":      "\x89PNG\r\n\x1a\n",
+		"data/raw":          "plain text",
+		".git/config":       "[core]",
+		"node_modules/x.js": "x",
+		"debug.log":         "log",
+	})
+
+	ctx := context.Background()
+	content := newMockLoadSaver()
+	ls := newMockLoadSaver()
+	ref, err := importer.Import(ctx, dir, content, ls, &importer.Options{
+		Ignore:        []string{".git", "node_modules", "*.log"},
+		IndexDocument: "index.html",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	root := mantaray.NewNodeRef(ref)
+	for _, tc := range []struct {
+		path        string
+		content     string
+		contentType string
+	}{
+		{"index.html", "<html></html>", "text/html; charset=utf-8"},
+		{"img/logo.png", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"data/raw", "plain text", "text/plain; charset=utf-8"},
+	} {
+		n, err := root.LookupNode(ctx, []byte(tc.path), ls)
+		if err != nil {
+			t.Fatalf("expected %s, got %v", tc.path, err)
+		}
+		data, err := content.Load(ctx, n.Entry())
+		if err != nil {
+			t.Fatalf("expected content of %s, got %v", tc.path, err)
+		}
+		if !bytes.Equal(data, []byte(tc.content)) {
+			t.Fatalf("expected content %q, got %q", tc.content, data)
+		}
+		if ct := n.Metadata()[importer.ContentTypeKey]; ct != tc.contentType {
+			t.Fatalf("expected content type %q on %s, got %q", tc.contentType, tc.path, ct)
+		}
+		if fn := n.Metadata()[importer.FilenameKey]; fn != filepath.Base(tc.path) {
+			t.Fatalf("expected filename %q, got %q", filepath.Base(tc.path), fn)
+		}
+		if m := n.Metadata()[importer.ModeKey]; m != "0644" {
+			t.Fatalf("expected mode 0644 on %s, got %q", tc.path, m)
+		}
+		if _, err := importer.ParseModTime(n.Metadata()[importer.ModTimeKey]); err != nil {
+			t.Fatalf("expected modification time on %s, got %v", tc.path, err)
+		}
+	}
+
+	for _, p := range []string{".git/config", "node_modules/x.js", "debug.log"} {
+		if _, err := root.Lookup(ctx, []byte(p), ls); !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("expected %s to be ignored, got %v", p, err)
+		}
+	}
+
+	n, err := root.LookupNode(ctx, []byte("/"), ls)
+	if err != nil {
+		t.Fatalf("expected root metadata, got %v", err)
+	}
+	if doc := n.Metadata()[importer.IndexDocumentKey]; doc != "index.html" {
+		t.Fatalf("expected index document index.html, got %q", doc)
+	}
+}
+
+func TestImportBadPattern(t *testing.T) {
+	_, err := importer.Import(context.Background(), ".", newMockLoadSaver(), newMockLoadSaver(), &importer.Options{
+		Ignore: []string{"["},
+	})
+	if err == nil {
+		t.Fatal("expected error for malformed pattern")
+	}
+}
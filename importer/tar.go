@@ -0,0 +1,75 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+// ImportTar adds every regular file of the tar archive read from r to a new
+// manifest and returns the reference of its saved root. The mode and
+// modification time of the file headers are recorded as metadata. When the
+// archive holds a path more than once, the last file wins.
+func ImportTar(ctx context.Context, r io.Reader, content, s mantaray.Saver, o *Options) ([]byte, error) {
+	if o == nil {
+		o = &Options{}
+	}
+	if err := validatePatterns(o.Ignore); err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	tr := tar.NewReader(r)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		p := archivePath(hdr.Name)
+		if p == "" || ignored(o.Ignore, p) {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		e, err := saveData(ctx, data, p, content)
+		if err != nil {
+			return nil, err
+		}
+		e.metadata[ModeKey] = FormatMode(hdr.FileInfo().Mode())
+		e.metadata[ModTimeKey] = FormatModTime(hdr.ModTime)
+		entries = append(entries, e)
+	}
+	return build(ctx, entries, rootMetadata(o), s, o)
+}
+
+// archivePath returns the manifest path of an archive member name, cleaned
+// so that it stays below the archive root. It is empty for the root itself.
+func archivePath(name string) string {
+	p := path.Clean("/" + strings.TrimPrefix(name, "./"))
+	if p == "/" {
+		return ""
+	}
+	return p[1:]
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/manifest/importer"
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+type tarFile struct {
+	name string
+	mode int64
+	data string
+}
+
+func writeTar(t *testing.T, files []tarFile) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Mode:     f.mode,
+			Size:     int64(len(f.data)),
+			ModTime:  time.Unix(1600000000, 0),
+		}
+		if f.data == "" && f.name[len(f.name)-1] == '/' {
+			hdr.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestImportTar(t *testing.T) {
+	buf := writeTar(t, []tarFile{
+		{"./site/", 0755, ""},
+		{"./site/index.html", 0644, "<html></html>"},
+		{"./bin/run.sh", 0755, "#!/bin/sh"},
+		{"../escape.txt", 0644, "escaped"},
+		{"./site/index.html", 0600, "<html>new</html>"},
+		{"build.log", 0644, "log"},
+	})
+
+	ctx := context.Background()
+	content := newMockLoadSaver()
+	ls := newMockLoadSaver()
+	ref, err := importer.ImportTar(ctx, buf, content, ls, &importer.Options{Ignore: []string{"*.log"}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	root := mantaray.NewNodeRef(ref)
+	for _, tc := range []struct {
+		path string
+		data string
+		mode string
+	}{
+		{"site/index.html", "<html>new</html>", "0600"},
+		{"bin/run.sh", "#!/bin/sh", "0755"},
+		{"escape.txt", "escaped", "0644"},
+	} {
+		n, err := root.LookupNode(ctx, []byte(tc.path), ls)
+		if err != nil {
+			t.Fatalf("expected %s, got %v", tc.path, err)
+		}
+		data, err := content.Load(ctx, n.Entry())
+		if err != nil {
+			t.Fatalf("expected content of %s, got %v", tc.path, err)
+		}
+		if string(data) != tc.data {
+			t.Fatalf("expected content %q of %s, got %q", tc.data, tc.path, data)
+		}
+		md := n.Metadata()
+		if md[importer.ModeKey] != tc.mode {
+			t.Fatalf("expected mode %s of %s, got %q", tc.mode, tc.path, md[importer.ModeKey])
+		}
+		if md[importer.ModTimeKey] != "1600000000" {
+			t.Fatalf("expected modification time of %s, got %q", tc.path, md[importer.ModTimeKey])
+		}
+		if md[importer.ContentTypeKey] == "" {
+			t.Fatalf("expected content type of %s", tc.path)
+		}
+	}
+	if _, err := root.Lookup(ctx, []byte("build.log"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected build.log to be ignored, got %v", err)
+	}
+}
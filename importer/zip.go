@@ -0,0 +1,88 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+// ImportZip adds every file of the zip archive of the given size read from
+// r to a new manifest and returns the reference of its saved root. The mode,
+// modification time and comment recorded in the central directory are set
+// as metadata, and the archive comment is set on the manifest root.
+// Directory entries are skipped unless Options.Directories is set.
+func ImportZip(ctx context.Context, r io.ReaderAt, size int64, content, s mantaray.Saver, o *Options) ([]byte, error) {
+	if o == nil {
+		o = &Options{}
+	}
+	if err := validatePatterns(o.Ignore); err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entry, 0, len(zr.File)+1)
+	for _, f := range zr.File {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		p := archivePath(f.Name)
+		if p == "" || ignored(o.Ignore, p) {
+			continue
+		}
+		info := f.FileInfo()
+		var e entry
+		switch {
+		case info.IsDir():
+			if !o.Directories {
+				continue
+			}
+			e = entry{
+				path:     p + "/",
+				metadata: make(map[string]string),
+			}
+		case info.Mode().IsRegular():
+			data, err := readZipFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if e, err = saveData(ctx, data, p, content); err != nil {
+				return nil, err
+			}
+		default:
+			continue
+		}
+		e.metadata[ModeKey] = FormatMode(info.Mode())
+		e.metadata[ModTimeKey] = FormatModTime(info.ModTime())
+		if f.Comment != "" {
+			e.metadata[CommentKey] = f.Comment
+		}
+		entries = append(entries, e)
+	}
+
+	root := rootMetadata(o)
+	if zr.Comment != "" {
+		root[CommentKey] = zr.Comment
+	}
+	return build(ctx, entries, root, s, o)
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
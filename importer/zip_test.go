@@ -0,0 +1,122 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/manifest/importer"
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func writeZip(t *testing.T) *bytes.Reader {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, f := range []struct {
+		name    string
+		comment string
+		data    string
+	}{
+		{"docs/", "", ""},
+		{"docs/readme.md", "read me first", "# readme"},
+		{"index.html", "", "<html></html>"},
+	} {
+		hdr := &zip.FileHeader{
+			Name:     f.name,
+			Comment:  f.comment,
+			Method:   zip.Deflate,
+			Modified: time.Unix(1600000000, 0).UTC(),
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.SetComment("release bundle"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestImportZip(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name        string
+		directories bool
+	}{
+		{"skip directories", false},
+		{"explicit directories", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := writeZip(t)
+			content := newMockLoadSaver()
+			ls := newMockLoadSaver()
+			ref, err := importer.ImportZip(ctx, r, r.Size(), content, ls, &importer.Options{Directories: tc.directories})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			root := mantaray.NewNodeRef(ref)
+
+			n, err := root.LookupNode(ctx, []byte("docs/readme.md"), ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			data, err := content.Load(ctx, n.Entry())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if string(data) != "# readme" {
+				t.Fatalf("expected content %q, got %q", "# readme", data)
+			}
+			md := n.Metadata()
+			if md[importer.CommentKey] != "read me first" {
+				t.Fatalf("expected comment, got %q", md[importer.CommentKey])
+			}
+			if md[importer.ModTimeKey] != "1600000000" {
+				t.Fatalf("expected modification time, got %q", md[importer.ModTimeKey])
+			}
+
+			n, err = root.LookupNode(ctx, []byte("/"), ls)
+			if err != nil {
+				t.Fatalf("expected root metadata, got %v", err)
+			}
+			if c := n.Metadata()[importer.CommentKey]; c != "release bundle" {
+				t.Fatalf("expected archive comment, got %q", c)
+			}
+
+			n, err = root.LookupNode(ctx, []byte("docs/"), ls)
+			if tc.directories {
+				if err != nil || !n.IsValueType() {
+					t.Fatalf("expected directory entry, got %v", err)
+				}
+			} else if err == nil && n.IsValueType() {
+				t.Fatal("expected directory entry to be skipped")
+			}
+			if _, err := root.Lookup(ctx, []byte("index.html"), ls); err != nil {
+				t.Fatalf("expected index.html, got %v", err)
+			}
+		})
+	}
+}
+
+func TestImportZipInvalid(t *testing.T) {
+	r := bytes.NewReader([]byte("not a zip"))
+	_, err := importer.ImportZip(context.Background(), r, r.Size(), newMockLoadSaver(), newMockLoadSaver(), nil)
+	if !errors.Is(err, zip.ErrFormat) {
+		t.Fatalf("expected format error, got %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// OpError is the failure of a single operation passed to Apply or ApplyOps.
+type OpError struct {
+	Index int
+	Op    *Op
+	Err   error
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("%s operation %d on '%s': %v", e.Op.Type, e.Index, e.Op.Path, e.Err)
+}
+
+// Unwrap returns the cause of the failure.
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError lists the operations that failed in ApplyOps, ordered by index.
+type BatchError struct {
+	Errors []*OpError
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more failed operations)", e.Errors[0], len(e.Errors)-1)
+}
+
+// Unwrap returns the failure of the first operation.
+func (e *BatchError) Unwrap() error {
+	return e.Errors[0]
+}
+
+// Is reports whether the failure of any operation matches target.
+func (e *BatchError) Is(target error) bool {
+	for _, oe := range e.Errors {
+		if errors.Is(oe.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// batchOp is an operation with its path relative to the node it is
+// applied on.
+type batchOp struct {
+	index int
+	op    *Op
+	path  []byte
+}
+
+// prefix returns the path of the node the operation is applied on.
+func (o batchOp) prefix() []byte {
+	return o.op.Path[:len(o.op.Path)-len(o.path)]
+}
+
+// ApplyOps applies the operations in a single descent per subtree.
+// Operations are sorted by path, keeping the given order for equal paths,
+// and every subtree is loaded at most once. Unlike Apply, operations on
+// different paths are not applied in the given order. If any operation
+// fails, including a failed precondition, the node is left unchanged and
+// a *BatchError lists every failed operation.
+func (n *Node) ApplyOps(ctx context.Context, ops []Op, ls LoadSaver) error {
+	b := &batch{ls: ls}
+	bops := make([]batchOp, 0, len(ops))
+	for i := range ops {
+		o := batchOp{index: i, op: &ops[i], path: ops[i].Path}
+		if _, ok := opTypeNames[o.op.Type]; !ok {
+			b.fail(o, fmt.Errorf("unknown operation type %d: %w", o.op.Type, ErrInvalid))
+			continue
+		}
+		if err := checkMetadata(o.op.Metadata); err != nil {
+			b.fail(o, err)
+			continue
+		}
+		bops = append(bops, o)
+	}
+	sort.SliceStable(bops, func(i, j int) bool {
+		return bytes.Compare(bops[i].path, bops[j].path) < 0
+	})
+	c := n.clone()
+	if _, _, err := b.apply(ctx, c, bops); err != nil {
+		return err
+	}
+	if len(b.errs) > 0 {
+		sort.Slice(b.errs, func(i, j int) bool { return b.errs[i].Index < b.errs[j].Index })
+		return &BatchError{Errors: b.errs}
+	}
+	c.touched = append(c.touched, b.touched...)
+	*n = *c
+	return nil
+}
+
+// batch applies sorted operations, collecting the failed operations and
+// the paths changed.
+type batch struct {
+	ls      LoadSaver
+	errs    []*OpError
+	touched [][]byte
+}
+
+func (b *batch) fail(o batchOp, err error) {
+	b.errs = append(b.errs, &OpError{Index: o.index, Op: o.op, Err: err})
+}
+
+// apply applies the operations to n, descending once into every fork
+// that a run of operations lies under. It reports whether n changed and
+// the path relative to n of the last entry added, which sets the path
+// separator flag of n as Add does. Only the cancellation of the context is
+// returned, other failures are recorded per operation.
+func (b *batch) apply(ctx context.Context, n *Node, ops []batchOp) (changed bool, added []byte, err error) {
+	select {
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, b.ls); err != nil {
+			for _, o := range ops {
+				b.fail(o, &LoadError{Op: "apply", Path: copyBytes(o.prefix()), Ref: n.ref, Err: err})
+			}
+			return false, nil, nil
+		}
+	}
+	for len(ops) > 0 {
+		o := ops[0]
+		if o.op.Type == OpAdd {
+			if err := n.checkEntrySize(o.op.Entry); err != nil {
+				b.fail(o, err)
+				ops = ops[1:]
+				continue
+			}
+		}
+		if len(o.path) == 0 {
+			// only on the node ApplyOps is called on
+			ops = ops[1:]
+			ok, err := b.applyHere(n, o)
+			if err != nil {
+				b.fail(o, err)
+			} else if ok {
+				changed = true
+			}
+			continue
+		}
+		f := n.forks[o.path[0]]
+		switch {
+		case f == nil || !bytes.HasPrefix(o.path, f.prefix):
+			// nothing is stored on the path, the fork is missing or has to
+			// be split
+			ops = ops[1:]
+			if err := b.insert(ctx, n, o); err != nil {
+				if ctx.Err() != nil {
+					return false, nil, ctx.Err()
+				}
+				b.fail(o, err)
+				continue
+			}
+			changed, added = true, o.path
+		case len(o.path) == len(f.prefix):
+			ops = ops[1:]
+			if f.Node.forks == nil {
+				if err := f.Node.load(ctx, b.ls); err != nil {
+					b.fail(o, &LoadError{Op: "apply", Path: copyBytes(o.op.Path), Ref: f.Node.ref, Err: err})
+					continue
+				}
+			}
+			if o.op.Type == OpAdd {
+				// the entry is serialised with the size of its own node
+				if err := f.Node.checkEntrySize(o.op.Entry); err != nil {
+					b.fail(o, err)
+					continue
+				}
+			}
+			ok, err := b.applyHere(f.Node, o)
+			if err != nil {
+				b.fail(o, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			changed = true
+			if o.op.Type == OpAdd {
+				added = o.path
+				f.Node.updateIsWithPathSeparator(added)
+			}
+			if !f.Node.IsValueType() && len(f.Node.forks) == 0 {
+				// removed with nothing nested under the path
+				delete(n.forks, o.path[0])
+			}
+		default:
+			j := 1
+			for j < len(ops) && len(ops[j].path) > len(f.prefix) && bytes.HasPrefix(ops[j].path, f.prefix) {
+				j++
+			}
+			sub := make([]batchOp, j)
+			for i, so := range ops[:j] {
+				so.path = so.path[len(f.prefix):]
+				sub[i] = so
+			}
+			ops = ops[j:]
+			ok, last, err := b.apply(ctx, f.Node, sub)
+			if err != nil {
+				return false, nil, err
+			}
+			if !ok {
+				continue
+			}
+			changed = true
+			if last != nil {
+				added = appendPath(f.prefix, last)
+				// NOTE: special case on edge split
+				f.Node.updateIsWithPathSeparator(added)
+			}
+		}
+	}
+	if changed {
+		n.ref = nil
+	}
+	return changed, added, nil
+}
+
+// applyHere applies an operation on the path of n itself, reporting
+// whether n changed.
+func (b *batch) applyHere(n *Node, o batchOp) (bool, error) {
+	if o.op.Expected != nil {
+		if err := checkExpected(n, o.op.Expected); err != nil {
+			return false, err
+		}
+	}
+	switch o.op.Type {
+	case OpAdd:
+		if n.IsValueType() && entryEqual(n.entry, o.op.Entry) && metadataEqual(n.metadata, o.op.Metadata) {
+			return false, nil
+		}
+		n.entry = o.op.Entry
+		n.makeValue()
+		n.setMetadata(o.op.Metadata)
+	case OpRemove:
+		if !n.IsValueType() {
+			return false, notFound("remove", o.op.Path, n.ref)
+		}
+		if len(o.op.Path) == 0 && len(n.forks) == 0 {
+			return false, ErrEmptyPath
+		}
+		// the parent removes the fork if nothing is nested under the path
+		n.makeNotValue()
+		n.entry = nil
+		n.setMetadata(nil)
+	case OpSetMetadata:
+		if !n.IsValueType() {
+			return false, notFound("apply", o.op.Path, n.ref)
+		}
+		if metadataEqual(n.metadata, o.op.Metadata) {
+			return false, nil
+		}
+		n.setMetadata(o.op.Metadata)
+	}
+	n.ref = nil
+	b.touched = append(b.touched, copyBytes(o.op.Path))
+	return true, nil
+}
+
+// insert applies an operation on a path below n that holds no entry,
+// which only an addition can satisfy.
+func (b *batch) insert(ctx context.Context, n *Node, o batchOp) error {
+	if o.op.Expected != nil {
+		if err := checkExpected(nil, o.op.Expected); err != nil {
+			return err
+		}
+	}
+	switch o.op.Type {
+	case OpRemove:
+		return notFound("remove", o.op.Path, n.ref)
+	case OpSetMetadata:
+		return notFound("apply", o.op.Path, n.ref)
+	}
+	if _, err := n.add(ctx, o.path, o.op.Entry, o.op.Metadata, b.ls); err != nil {
+		return prependPath(o.prefix(), err)
+	}
+	b.touched = append(b.touched, copyBytes(o.op.Path))
+	return nil
+}
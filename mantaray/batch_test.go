@@ -0,0 +1,208 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+type recordingLoader struct {
+	mantaray.LoadSaver
+	loads map[string]int
+}
+
+func (l *recordingLoader) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	l.loads[string(ref)]++
+	return l.LoadSaver.Load(ctx, ref)
+}
+
+func TestApplyBatch(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	n := mantaray.New()
+	for i := 0; i < 100; i++ {
+		p := fmt.Sprintf("dir%d/file%03d", i%4, i)
+		if err := n.Add(ctx, []byte(p), testEntry(p), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// add in reverse order and remove every tenth existing file
+	var ops []mantaray.Op
+	for i := 199; i >= 100; i-- {
+		p := fmt.Sprintf("dir%d/file%03d", i%4, i)
+		ops = append(ops, mantaray.Op{Type: mantaray.OpAdd, Path: []byte(p), Entry: testEntry(p)})
+	}
+	for i := 0; i < 100; i += 10 {
+		p := fmt.Sprintf("dir%d/file%03d", i%4, i)
+		ops = append(ops, mantaray.Op{Type: mantaray.OpRemove, Path: []byte(p), Expected: &mantaray.Precondition{Entry: testEntry(p)}})
+	}
+
+	rl := &recordingLoader{LoadSaver: ls, loads: make(map[string]int)}
+	m := mantaray.NewNodeRef(n.Reference())
+	if err := m.ApplyOps(ctx, ops, rl); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for ref, c := range rl.loads {
+		if c > 1 {
+			t.Fatalf("expected node %x to be loaded once, got %d", ref, c)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		p := fmt.Sprintf("dir%d/file%03d", i%4, i)
+		e, err := m.Lookup(ctx, []byte(p), ls)
+		if i < 100 && i%10 == 0 {
+			if !errors.Is(err, mantaray.ErrNotFound) {
+				t.Fatalf("expected %s to be removed, got %v", p, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("expected no error on %s, got %v", p, err)
+		}
+		if !bytes.Equal(e, testEntry(p)) {
+			t.Fatalf("expected entry %x on %s, got %x", testEntry(p), p, e)
+		}
+	}
+}
+
+func TestApplyBatchErrors(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	n := mantaray.New()
+	if err := n.Add(ctx, []byte("a/1"), testEntry("a/1"), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ref := n.Reference()
+
+	ops := []mantaray.Op{
+		{Type: mantaray.OpAdd, Path: []byte("a/2"), Entry: testEntry("a/2")},
+		{Type: mantaray.OpAdd, Path: []byte("a/3"), Entry: []byte("short")},
+		{Type: mantaray.OpRemove, Path: []byte("b")},
+		{Type: mantaray.OpAdd, Path: []byte("a/1"), Entry: testEntry("a/1"), Expected: &mantaray.Precondition{Missing: true}},
+	}
+	err := n.ApplyOps(ctx, ops, ls)
+	var be *mantaray.BatchError
+	if !errors.As(err, &be) {
+		t.Fatalf("expected batch error, got %v", err)
+	}
+	var failed []int
+	for _, oe := range be.Errors {
+		failed = append(failed, oe.Index)
+	}
+	if fmt.Sprint(failed) != "[1 2 3]" {
+		t.Fatalf("expected operations [1 2 3] to fail, got %v", failed)
+	}
+	if !errors.Is(err, mantaray.ErrNotFound) || !errors.Is(err, mantaray.ErrPreconditionFailed) {
+		t.Fatalf("expected not found and precondition errors, got %v", err)
+	}
+
+	if !bytes.Equal(n.Reference(), ref) {
+		t.Fatalf("expected node to be unchanged")
+	}
+	if _, err := n.Lookup(ctx, []byte("a/2"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected a/2 not to be added, got %v", err)
+	}
+}
+
+func TestApplyOpsMatchesApply(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	ref := savedManifest(t, ls, map[string]map[string]string{
+		"dir/a.txt":   nil,
+		"dir/b.txt":   {"Content-Type": "text/plain"},
+		"dir/sub/c":   nil,
+		"dir/sub/d":   nil,
+		"index.html":  nil,
+		"index.json":  nil,
+		"img/logo.sv": nil,
+	})
+
+	// sorted by path, splitting prefixes and removing whole subtrees
+	ops := []mantaray.Op{
+		{Type: mantaray.OpAdd, Path: []byte("d"), Entry: testEntry("d")},
+		{Type: mantaray.OpAdd, Path: []byte("dir/"), Entry: testEntry("dir/")},
+		{Type: mantaray.OpRemove, Path: []byte("dir/a.txt"), Expected: &mantaray.Precondition{Entry: testEntry("dir/a.txt")}},
+		{Type: mantaray.OpAdd, Path: []byte("dir/b.txt"), Entry: testEntry("dir/b.txt")},
+		{Type: mantaray.OpAdd, Path: []byte("dir/b.txt.bak"), Entry: testEntry("dir/b.txt.bak")},
+		{Type: mantaray.OpRemove, Path: []byte("dir/sub/c")},
+		{Type: mantaray.OpRemove, Path: []byte("dir/sub/d")},
+		{Type: mantaray.OpSetMetadata, Path: []byte("img/logo.sv"), Metadata: map[string]string{"Content-Type": "image/svg"}},
+		{Type: mantaray.OpAdd, Path: []byte("index"), Entry: testEntry("index"), Expected: &mantaray.Precondition{Missing: true}},
+		{Type: mantaray.OpAdd, Path: []byte("index.html"), Entry: testEntry("index.html")},
+	}
+
+	want := mantaray.NewNodeRef(ref)
+	if err := want.Apply(ctx, &mantaray.Patch{Ops: ops}, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := want.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// apply in reverse, the result does not depend on the order
+	reversed := make([]mantaray.Op, len(ops))
+	for i := range ops {
+		reversed[len(ops)-1-i] = ops[i]
+	}
+	got := mantaray.NewNodeRef(ref)
+	if err := got.ApplyOps(ctx, reversed, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := got.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(got.Reference(), want.Reference()) {
+		t.Fatalf("expected reference %x, got %x", want.Reference(), got.Reference())
+	}
+}
+
+func TestApplyOpsEntrySize(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := mantaray.New()
+	// the directory node is created without an entry size
+	if err := n.Add(ctx, []byte("dir/"), nil, nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err := n.ApplyOps(ctx, []mantaray.Op{
+		{Type: mantaray.OpAdd, Path: []byte("dir/"), Entry: testEntry("dir/")},
+	}, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	entry, err := mantaray.NewNodeRef(n.Reference()).Lookup(ctx, []byte("dir/"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(entry, testEntry("dir/")) {
+		t.Fatalf("expected entry %x, got %x", testEntry("dir/"), entry)
+	}
+
+	err = n.ApplyOps(ctx, []mantaray.Op{
+		{Type: mantaray.OpAdd, Path: []byte("dir/"), Entry: make([]byte, 64)},
+	}, ls)
+	var be *mantaray.BatchError
+	if !errors.As(err, &be) || len(be.Errors) != 1 || be.Errors[0].Index != 0 {
+		t.Fatalf("expected entry size error, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrUnsorted is returned when paths are not added to a Builder in strictly
+// increasing order.
+var ErrUnsorted = errors.New("path not in sorted order")
+
+// Builder creates a manifest from entries added in sorted path order. Every
+// subtree is saved as soon as no later path can reach it, so only the nodes
+// along the last added path are kept in memory. The resulting trie has the
+// same structure as one created with Node.Add.
+type Builder struct {
+	root  *Node
+	s     Saver
+	o     *SaveOptions
+	last  []byte
+	added bool
+}
+
+// BuilderOptions configure a Builder.
+type BuilderOptions struct {
+	// MaxNodeSize is the maximum size of a saved node, as in SaveOptions.
+	MaxNodeSize int
+}
+
+// NewBuilder returns a Builder persisting nodes through s.
+func NewBuilder(s Saver, o *BuilderOptions) *Builder {
+	if o == nil {
+		o = &BuilderOptions{}
+	}
+	return &Builder{
+		root: New(),
+		s:    s,
+		o:    &SaveOptions{MaxNodeSize: o.MaxNodeSize},
+	}
+}
+
+// SetObfuscationKey sets the obfuscation key used for all nodes.
+func (b *Builder) SetObfuscationKey(obfuscationKey []byte) {
+	b.root.SetObfuscationKey(obfuscationKey)
+}
+
+// Add adds an entry to the path, which must sort after all the paths added
+// before.
+func (b *Builder) Add(ctx context.Context, path, entry []byte, metadata map[string]string) error {
+	if b.added && bytes.Compare(path, b.last) <= 0 {
+		return fmt.Errorf("'%s' after '%s': %w", path, b.last, ErrUnsorted)
+	}
+	if err := checkMetadata(metadata); err != nil {
+		return err
+	}
+	// completed subtrees are never reached again, so no loader is needed,
+	// and the added paths are not recorded for Pending to bound the memory
+	if _, err := b.root.add(ctx, path, entry, metadata, nil); err != nil {
+		return err
+	}
+	b.last = append(b.last[:0], path...)
+	b.added = true
+	return b.flush(ctx, path)
+}
+
+// flush saves the subtrees left of path, which no later path can reach.
+func (b *Builder) flush(ctx context.Context, path []byte) error {
+	n := b.root
+	for len(path) > 0 {
+		for k, f := range n.forks {
+			if k < path[0] && f.Node.ref == nil {
+				if err := f.Node.SaveWithOptions(ctx, b.s, b.o); err != nil {
+					return err
+				}
+			}
+		}
+		f := n.forks[path[0]]
+		path = path[len(f.prefix):]
+		n = f.Node
+	}
+	return nil
+}
+
+// Finish saves the remaining nodes and returns the reference of the root.
+// The Builder must not be used afterwards.
+func (b *Builder) Finish(ctx context.Context) ([]byte, error) {
+	if err := b.root.SaveWithOptions(ctx, b.s, b.o); err != nil {
+		return nil, err
+	}
+	return b.root.Reference(), nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+type countingSaver struct {
+	mantaray.LoadSaver
+
+	mu    sync.Mutex
+	saves int
+}
+
+func (s *countingSaver) Save(ctx context.Context, b []byte) ([]byte, error) {
+	s.mu.Lock()
+	s.saves++
+	s.mu.Unlock()
+	return s.LoadSaver.Save(ctx, b)
+}
+
+// builderEntry returns an entry for paths longer than testEntry allows.
+func builderEntry(path string) []byte {
+	h := sha256.Sum256([]byte(path))
+	return h[:]
+}
+
+func TestBuilder(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	var paths []string
+	for i := 0; i < 300; i++ {
+		paths = append(paths, fmt.Sprintf("data/%03d/part-%d.bin", i%37, i))
+	}
+	paths = append(paths, "index.html", "img/logo.png", "img/", "a-very-long-directory-name-exceeding-the-prefix-limit/file.txt")
+	sort.Strings(paths)
+
+	cs := &countingSaver{LoadSaver: ls}
+	b := mantaray.NewBuilder(cs, nil)
+	b.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+	for i, p := range paths {
+		if err := b.Add(ctx, []byte(p), builderEntry(p), nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if i == len(paths)/2 && cs.saves == 0 {
+			t.Fatalf("expected completed subtrees to be saved while adding")
+		}
+	}
+	ref, err := b.Finish(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n := mantaray.New()
+	n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+	for _, p := range paths {
+		if err := n.Add(ctx, []byte(p), builderEntry(p), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(ref, n.Reference()) {
+		t.Fatalf("expected reference %x, got %x", n.Reference(), ref)
+	}
+}
+
+func TestBuilderUnsorted(t *testing.T) {
+	ctx := context.Background()
+	b := mantaray.NewBuilder(newMockLoadSaver(), nil)
+	if err := b.Add(ctx, []byte("b"), testEntry("b"), nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, p := range []string{"a", "b"} {
+		if err := b.Add(ctx, []byte(p), testEntry(p), nil); !errors.Is(err, mantaray.ErrUnsorted) {
+			t.Fatalf("expected unsorted error for %s, got %v", p, err)
+		}
+	}
+}
+
+func TestBuilderMaxNodeSize(t *testing.T) {
+	ctx := context.Background()
+	var paths []string
+	for i := 0; i < 10; i++ {
+		paths = append(paths, fmt.Sprintf("doc/%c.txt", 'a'+i))
+	}
+	metadata := func(p string) map[string]string {
+		return map[string]string{"Description": strings.Repeat(p, 100)}
+	}
+	build := func(o *mantaray.BuilderOptions) ([]byte, mantaray.LoadSaver, error) {
+		ls := &sizeCheckingSaver{LoadSaver: newMockLoadSaver(), max: mantaray.ChunkSize}
+		b := mantaray.NewBuilder(ls, o)
+		for _, p := range paths {
+			if err := b.Add(ctx, []byte(p), builderEntry(p), metadata(p)); err != nil {
+				return nil, nil, err
+			}
+		}
+		ref, err := b.Finish(ctx)
+		return ref, ls, err
+	}
+
+	if _, _, err := build(nil); err == nil {
+		t.Fatal("expected a node larger than a chunk without a limit")
+	}
+	ref, ls, err := build(&mantaray.BuilderOptions{MaxNodeSize: mantaray.ChunkSize})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, p := range paths {
+		n, err := mantaray.NewNodeRef(ref).LookupNode(ctx, []byte(p), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(n.Metadata(), metadata(p)) {
+			t.Fatalf("%s: unexpected metadata %v", p, n.Metadata())
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheOptions configure a CachingLoader. A zero limit is not enforced.
+type CacheOptions struct {
+	// MaxBytes limits the total size of the cached data.
+	MaxBytes int
+	// MaxEntries limits the number of cached references.
+	MaxEntries int
+}
+
+// CacheStats are the counters of a CachingLoader.
+type CacheStats struct {
+	// Hits counts loads served from the cache.
+	Hits uint64
+	// Misses counts loads not found in the cache.
+	Misses uint64
+	// Loads counts loads passed to the wrapped loader. It is lower than
+	// Misses when concurrent loads of the same reference are shared.
+	Loads uint64
+	// Evictions counts references removed to respect the limits.
+	Evictions uint64
+}
+
+// CachingLoader is a Loader keeping recently loaded data in a least
+// recently used cache. Concurrent loads of the same reference are passed
+// to the wrapped loader once. The returned data is shared and must not be
+// modified.
+type CachingLoader struct {
+	l     Loader
+	o     CacheOptions
+	group singleflight.Group
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	size  int
+	stats CacheStats
+}
+
+type cacheItem struct {
+	ref  string
+	data []byte
+}
+
+// NewCachingLoader returns a loader caching the data loaded through l.
+func NewCachingLoader(l Loader, o *CacheOptions) *CachingLoader {
+	if o == nil {
+		o = &CacheOptions{}
+	}
+	return &CachingLoader{
+		l:     l,
+		o:     *o,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Load implements Loader. A load shared by concurrent callers is not
+// canceled with the context of any of them, and each caller stops waiting
+// for it when its own context is done.
+func (c *CachingLoader) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	key := string(ref)
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return e.Value.(*cacheItem).data, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	lctx := detach(ctx)
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		c.mu.Lock()
+		if e, ok := c.items[key]; ok {
+			// added by a load that completed in the meantime
+			c.mu.Unlock()
+			return e.Value.(*cacheItem).data, nil
+		}
+		c.stats.Loads++
+		c.mu.Unlock()
+		data, err := c.l.Load(lctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		c.add(key, data)
+		return data, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.([]byte), nil
+	}
+}
+
+// Stats returns the current counters.
+func (c *CachingLoader) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Len returns the number of cached references.
+func (c *CachingLoader) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Size returns the total size of the cached data.
+func (c *CachingLoader) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *CachingLoader) add(key string, data []byte) {
+	if c.o.MaxBytes > 0 && len(data) > c.o.MaxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheItem{ref: key, data: data})
+	c.size += len(data)
+	for (c.o.MaxBytes > 0 && c.size > c.o.MaxBytes) || (c.o.MaxEntries > 0 && c.ll.Len() > c.o.MaxEntries) {
+		e := c.ll.Back()
+		item := e.Value.(*cacheItem)
+		c.ll.Remove(e)
+		delete(c.items, item.ref)
+		c.size -= len(item.data)
+		c.stats.Evictions++
+	}
+}
+
+// detachedContext carries the values of a context without its deadline and
+// cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+// detach returns a context with the values of ctx that is never canceled.
+// It is used by loads shared by callers with different contexts.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
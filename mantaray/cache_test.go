@@ -0,0 +1,172 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+type blockingLoader struct {
+	mantaray.Loader
+	release chan struct{}
+}
+
+func (l *blockingLoader) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	select {
+	case <-l.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return l.Loader.Load(ctx, ref)
+}
+
+func TestCachingLoaderLookup(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	ref := savedManifest(t, ls, map[string]map[string]string{
+		"index.html": nil,
+		"img/1.png":  nil,
+		"img/2.png":  nil,
+	})
+
+	cl := mantaray.NewCachingLoader(ls, nil)
+	for i := 0; i < 3; i++ {
+		for _, p := range []string{"index.html", "img/1.png", "img/2.png"} {
+			n := mantaray.NewNodeRef(ref)
+			if _, err := n.Lookup(ctx, []byte(p), cl); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+	}
+	s := cl.Stats()
+	if s.Loads != uint64(cl.Len()) || s.Misses != s.Loads {
+		t.Fatalf("expected every node to be loaded once, got %+v", s)
+	}
+	if s.Hits == 0 {
+		t.Fatalf("expected cache hits, got %+v", s)
+	}
+}
+
+func TestCachingLoaderEviction(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	var refs [][]byte
+	for _, d := range []string{"aaaa", "bbbb", "cccc"} {
+		ref, err := ls.Save(ctx, []byte(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+
+	for _, o := range []*mantaray.CacheOptions{{MaxEntries: 2}, {MaxBytes: 8}} {
+		cl := mantaray.NewCachingLoader(ls, o)
+		// a, b, a, c evicts b as the least recently used
+		for _, i := range []int{0, 1, 0, 2} {
+			if _, err := cl.Load(ctx, refs[i]); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if s := cl.Stats(); s.Evictions != 1 || s.Hits != 1 {
+			t.Fatalf("expected 1 eviction and 1 hit, got %+v", s)
+		}
+		if _, err := cl.Load(ctx, refs[0]); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if s := cl.Stats(); s.Hits != 2 {
+			t.Fatalf("expected a to be cached, got %+v", s)
+		}
+		if _, err := cl.Load(ctx, refs[1]); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if s := cl.Stats(); s.Misses != 4 {
+			t.Fatalf("expected b to be evicted, got %+v", s)
+		}
+		if cl.Len() != 2 || cl.Size() != 8 {
+			t.Fatalf("expected 2 entries of 8 bytes, got %d of %d", cl.Len(), cl.Size())
+		}
+	}
+}
+
+func TestCachingLoaderConcurrent(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	ref, err := ls.Save(ctx, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := &blockingLoader{Loader: ls, release: make(chan struct{})}
+	cl := mantaray.NewCachingLoader(bl, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cl.Load(ctx, ref); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		}()
+	}
+	// wait for all loads to be issued before releasing the shared one
+	for {
+		if s := cl.Stats(); s.Misses+s.Hits == 10 {
+			break
+		}
+		runtime.Gosched()
+	}
+	close(bl.release)
+	wg.Wait()
+	if s := cl.Stats(); s.Loads != 1 {
+		t.Fatalf("expected a single load, got %+v", s)
+	}
+}
+
+func TestCachingLoaderCanceled(t *testing.T) {
+	ls := newMockLoadSaver()
+	ref, err := ls.Save(context.Background(), []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := &blockingLoader{Loader: ls, release: make(chan struct{})}
+	cl := mantaray.NewCachingLoader(bl, nil)
+
+	// the first caller starts the shared load and gives up on it
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := cl.Load(ctx, ref)
+		canceled <- err
+	}()
+	for cl.Stats().Loads == 0 {
+		runtime.Gosched()
+	}
+	done := make(chan error)
+	go func() {
+		_, err := cl.Load(context.Background(), ref)
+		done <- err
+	}()
+	for cl.Stats().Misses != 2 {
+		runtime.Gosched()
+	}
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+
+	close(bl.release)
+	if err := <-done; err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if s := cl.Stats(); s.Loads != 1 || cl.Len() != 1 {
+		t.Fatalf("expected a single cached load, got %+v", s)
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"sort"
+)
+
+// DiffType describes how an entry differs between two manifests.
+type DiffType int
+
+const (
+	// DiffAdded marks an entry present only in the new manifest.
+	DiffAdded DiffType = iota + 1
+	// DiffRemoved marks an entry present only in the old manifest.
+	DiffRemoved
+	// DiffModified marks an entry whose value or metadata changed.
+	DiffModified
+)
+
+func (t DiffType) String() string {
+	switch t {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffModified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// DiffFunc is the type of the function called for each entry that differs
+// between the manifests compared by Diff. The old node is nil for added
+// entries and the new node is nil for removed ones.
+type DiffFunc func(path []byte, t DiffType, old, new *Node) error
+
+// Diff compares the entries of the manifests rooted at a and b, calling fn
+// for every added, removed or modified entry in lexicographic path order.
+// Subtrees with equal references on both sides are skipped without loading.
+// If l is a BatchLoader, the forks compared under a node are loaded with
+// one call.
+func Diff(ctx context.Context, a, b *Node, l Loader, fn DiffFunc) error {
+	return diff(ctx, []byte{}, a, b, l, fn)
+}
+
+func diff(ctx context.Context, path []byte, a, b *Node, l Loader, fn DiffFunc) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	// equal references imply equal entries and descendants, but the type
+	// and metadata are kept on the parent fork
+	sameRef := a.ref != nil && bytes.Equal(a.ref, b.ref)
+	if sameRef && a.IsValueType() == b.IsValueType() && metadataEqual(a.metadata, b.metadata) {
+		return nil
+	}
+	if a.forks == nil {
+		if err := a.load(ctx, l); err != nil {
+			return &LoadError{Op: "diff", Path: copyBytes(path), Ref: a.ref, Err: err}
+		}
+	}
+	if b.forks == nil {
+		if err := b.load(ctx, l); err != nil {
+			return &LoadError{Op: "diff", Path: copyBytes(path), Ref: b.ref, Err: err}
+		}
+	}
+	if a.IsValueType() && b.IsValueType() {
+		if err := a.resolveMetadata(ctx, l); err != nil {
+			return &LoadError{Op: "diff", Path: copyBytes(path), Ref: a.ref, Err: err}
+		}
+		if err := b.resolveMetadata(ctx, l); err != nil {
+			return &LoadError{Op: "diff", Path: copyBytes(path), Ref: b.ref, Err: err}
+		}
+	}
+	if err := diffValue(path, a, b, fn); err != nil {
+		return err
+	}
+	if sameRef {
+		return nil
+	}
+	if err := loadDiffForks(ctx, a, b, l); err != nil {
+		return &LoadError{Op: "diff", Path: copyBytes(path), Err: err}
+	}
+	for _, k := range mergeKeys(sortedKeys(a.forks), sortedKeys(b.forks)) {
+		fa, fb := a.forks[k], b.forks[k]
+		var err error
+		switch {
+		case fb == nil:
+			err = entries(ctx, appendPath(path, fa.prefix), fa.Node, l, func(p []byte, n *Node) error {
+				return fn(p, DiffRemoved, n, nil)
+			})
+		case fa == nil:
+			err = entries(ctx, appendPath(path, fb.prefix), fb.Node, l, func(p []byte, n *Node) error {
+				return fn(p, DiffAdded, nil, n)
+			})
+		case bytes.Equal(fa.prefix, fb.prefix):
+			err = diff(ctx, appendPath(path, fa.prefix), fa.Node, fb.Node, l, fn)
+		default:
+			err = diffForks(ctx, path, fa, fb, l, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadDiffForks loads the forks of a and b that diff visits with one call
+// if l is a BatchLoader. Forks with the same prefix and reference on both
+// sides are left out, as they are skipped when their types and metadata
+// also match.
+func loadDiffForks(ctx context.Context, a, b *Node, l Loader) error {
+	if _, ok := l.(BatchLoader); !ok {
+		return nil
+	}
+	var nodes []*Node
+	for _, k := range mergeKeys(sortedKeys(a.forks), sortedKeys(b.forks)) {
+		fa, fb := a.forks[k], b.forks[k]
+		if fa != nil && fb != nil && bytes.Equal(fa.prefix, fb.prefix) && fa.Node.ref != nil && bytes.Equal(fa.Node.ref, fb.Node.ref) {
+			continue
+		}
+		if fa != nil {
+			nodes = append(nodes, fa.Node)
+		}
+		if fb != nil {
+			nodes = append(nodes, fb.Node)
+		}
+	}
+	return loadAll(ctx, l, nodes)
+}
+
+// diffValue reports the difference between the values held by two nodes
+// on the same path.
+func diffValue(path []byte, a, b *Node, fn DiffFunc) error {
+	switch av, bv := a.IsValueType(), b.IsValueType(); {
+	case av && !bv:
+		return fn(appendPath(path, nil), DiffRemoved, a, nil)
+	case !av && bv:
+		return fn(appendPath(path, nil), DiffAdded, nil, b)
+	case av && bv:
+		if !entryEqual(a.entry, b.entry) || !metadataEqual(a.metadata, b.metadata) {
+			return fn(appendPath(path, nil), DiffModified, a, b)
+		}
+	}
+	return nil
+}
+
+type pathNode struct {
+	path []byte
+	node *Node
+}
+
+// diffForks compares two forks on the same byte whose prefixes differ, so
+// the subtrees cannot be walked side by side.
+func diffForks(ctx context.Context, path []byte, fa, fb *fork, l Loader, fn DiffFunc) error {
+	var as, bs []pathNode
+	err := entries(ctx, appendPath(path, fa.prefix), fa.Node, l, func(p []byte, n *Node) error {
+		as = append(as, pathNode{p, n})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = entries(ctx, appendPath(path, fb.prefix), fb.Node, l, func(p []byte, n *Node) error {
+		bs = append(bs, pathNode{p, n})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for len(as) > 0 || len(bs) > 0 {
+		c := 0
+		switch {
+		case len(as) == 0:
+			c = 1
+		case len(bs) == 0:
+			c = -1
+		default:
+			c = bytes.Compare(as[0].path, bs[0].path)
+		}
+		switch {
+		case c < 0:
+			err = fn(as[0].path, DiffRemoved, as[0].node, nil)
+			as = as[1:]
+		case c > 0:
+			err = fn(bs[0].path, DiffAdded, nil, bs[0].node)
+			bs = bs[1:]
+		default:
+			err = diffValue(as[0].path, as[0].node, bs[0].node, fn)
+			as, bs = as[1:], bs[1:]
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entries calls fn for every value node of the subtree rooted at n in
+// lexicographic path order.
+func entries(ctx context.Context, path []byte, n *Node, l Loader, fn func(path []byte, n *Node) error) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, l); err != nil {
+			return &LoadError{Op: "diff", Path: copyBytes(path), Ref: n.ref, Err: err}
+		}
+	}
+	if n.IsValueType() {
+		if err := n.resolveMetadata(ctx, l); err != nil {
+			return &LoadError{Op: "diff", Path: copyBytes(path), Ref: n.ref, Err: err}
+		}
+		if err := fn(appendPath(path, nil), n); err != nil {
+			return err
+		}
+	}
+	if _, ok := l.(BatchLoader); ok {
+		if err := loadAll(ctx, l, forkNodes(n)); err != nil {
+			return &LoadError{Op: "diff", Path: copyBytes(path), Ref: n.ref, Err: err}
+		}
+	}
+	for _, k := range sortedKeys(n.forks) {
+		f := n.forks[k]
+		if err := entries(ctx, appendPath(path, f.prefix), f.Node, l, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendPath returns a new slice holding path followed by prefix.
+func appendPath(path, prefix []byte) []byte {
+	p := make([]byte, 0, len(path)+len(prefix))
+	p = append(p, path...)
+	return append(p, prefix...)
+}
+
+func sortedKeys(forks map[byte]*fork) []byte {
+	keys := make([]byte, 0, len(forks))
+	for k := range forks {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// mergeKeys returns the sorted union of two sorted key lists.
+func mergeKeys(a, b []byte) []byte {
+	keys := make([]byte, 0, len(a)+len(b))
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0] < b[0]):
+			keys = append(keys, a[0])
+			a = a[1:]
+		case len(a) == 0 || b[0] < a[0]:
+			keys = append(keys, b[0])
+			b = b[1:]
+		default:
+			keys = append(keys, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return keys
+}
+
+// entryEqual compares two entries treating an empty entry as equal to the
+// zero padded one it is persisted as.
+func entryEqual(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	return isZero(a) && isZero(b)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func metadataEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
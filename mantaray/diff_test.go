@@ -0,0 +1,152 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+type diffEntry struct {
+	path string
+	typ  mantaray.DiffType
+}
+
+func testEntry(path string) []byte {
+	return append(make([]byte, 32-len(path)), path...)
+}
+
+func TestDiff(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		old      []string
+		new      []string
+		modified []string
+		expected []diffEntry
+	}{
+		{
+			name: "equal",
+			old:  []string{"index.html", "img/1.png", "img/2.png"},
+			new:  []string{"index.html", "img/1.png", "img/2.png"},
+		},
+		{
+			name: "added-and-removed",
+			old:  []string{"index.html", "img/1.png", "img/2.png"},
+			new:  []string{"index.html", "img/2.png", "img/3.png", "robots.txt"},
+			expected: []diffEntry{
+				{"img/1.png", mantaray.DiffRemoved},
+				{"img/3.png", mantaray.DiffAdded},
+				{"robots.txt", mantaray.DiffAdded},
+			},
+		},
+		{
+			name:     "modified",
+			old:      []string{"index.html", "img/1.png"},
+			new:      []string{"index.html", "img/1.png"},
+			modified: []string{"img/1.png"},
+			expected: []diffEntry{
+				{"img/1.png", mantaray.DiffModified},
+			},
+		},
+		{
+			name: "split-prefix",
+			old:  []string{"img/test/1.png"},
+			new:  []string{"img/test/1.png", "img/tent.png"},
+			expected: []diffEntry{
+				{"img/tent.png", mantaray.DiffAdded},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			ls := newMockLoadSaver()
+
+			build := func(paths []string, modified []string) *mantaray.Node {
+				n := mantaray.New()
+				for _, p := range paths {
+					e := testEntry(p)
+					for _, m := range modified {
+						if m == p {
+							e = testEntry(p + "~")
+						}
+					}
+					if err := n.Add(ctx, []byte(p), e, nil, ls); err != nil {
+						t.Fatalf("expected no error, got %v", err)
+					}
+				}
+				if err := n.Save(ctx, ls); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return mantaray.NewNodeRef(n.Reference())
+			}
+
+			var got []diffEntry
+			err := mantaray.Diff(ctx, build(tc.old, nil), build(tc.new, tc.modified), ls, func(path []byte, typ mantaray.DiffType, old, new *mantaray.Node) error {
+				got = append(got, diffEntry{string(path), typ})
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+type countingLoader struct {
+	mantaray.LoadSaver
+	loads int
+}
+
+func (l *countingLoader) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	l.loads++
+	return l.LoadSaver.Load(ctx, ref)
+}
+
+func TestDiffSkipsEqualSubtrees(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	n := mantaray.New()
+	for i := 0; i < 20; i++ {
+		p := fmt.Sprintf("dir/%02d.txt", i)
+		if err := n.Add(ctx, []byte(p), testEntry(p), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	old := n.Reference()
+	if err := n.Add(ctx, []byte("index.html"), testEntry("index.html"), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	cl := &countingLoader{LoadSaver: ls}
+	var paths [][]byte
+	err := mantaray.Diff(ctx, mantaray.NewNodeRef(old), mantaray.NewNodeRef(n.Reference()), cl, func(path []byte, typ mantaray.DiffType, old, new *mantaray.Node) error {
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(paths) != 1 || !bytes.Equal(paths[0], []byte("index.html")) {
+		t.Fatalf("expected only index.html to differ, got %s", paths)
+	}
+	// only the two roots and the new leaf are loaded
+	if cl.loads != 3 {
+		t.Fatalf("expected 3 loads, got %d", cl.loads)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+)
+
+// EditorOptions configure an Editor.
+type EditorOptions struct {
+	// MemoryBudget limits the estimated size of the nodes kept in memory
+	// between edits. A value of 0 sets no limit.
+	MemoryBudget int
+	// MaxNodeSize is the maximum size of a saved node, as in SaveOptions.
+	MaxNodeSize int
+}
+
+// Editor applies edits to a trie, keeping the nodes held in memory within
+// a budget. When the budget is exceeded, subtrees that are saved are
+// unloaded first, and edits then save modified subtrees early. Early saves
+// may store nodes that later edits replace.
+type Editor struct {
+	root   *Node
+	ls     LoadSaver
+	budget int
+	o      *SaveOptions
+}
+
+// NewEditor returns an editor of the trie rooted at root.
+func NewEditor(root *Node, ls LoadSaver, o *EditorOptions) *Editor {
+	if o == nil {
+		o = &EditorOptions{}
+	}
+	return &Editor{
+		root:   root,
+		ls:     ls,
+		budget: o.MemoryBudget,
+		o:      &SaveOptions{MaxNodeSize: o.MaxNodeSize},
+	}
+}
+
+// Root returns the root of the edited trie.
+func (e *Editor) Root() *Node {
+	return e.root
+}
+
+// Lookup returns the entry at path. It unloads saved subtrees to respect
+// the budget, but never saves.
+func (e *Editor) Lookup(ctx context.Context, path []byte) ([]byte, error) {
+	entry, err := e.root.Lookup(ctx, path, e.ls)
+	if err != nil {
+		return nil, err
+	}
+	return entry, e.shrink(ctx, false)
+}
+
+// Add adds an entry to the path.
+func (e *Editor) Add(ctx context.Context, path, entry []byte, metadata map[string]string) error {
+	if err := e.root.Add(ctx, path, entry, metadata, e.ls); err != nil {
+		return err
+	}
+	return e.shrink(ctx, true)
+}
+
+// Remove removes the entry at path.
+func (e *Editor) Remove(ctx context.Context, path []byte) error {
+	if err := e.root.Remove(ctx, path, e.ls); err != nil {
+		return err
+	}
+	return e.shrink(ctx, true)
+}
+
+// Save saves the trie and returns the reference of its root.
+func (e *Editor) Save(ctx context.Context) ([]byte, error) {
+	if err := e.root.SaveWithOptions(ctx, e.ls, e.o); err != nil {
+		return nil, err
+	}
+	return e.root.Reference(), nil
+}
+
+// MemoryUsage returns the estimated size of the nodes held in memory and
+// of the paths recorded for Pending.
+func (e *Editor) MemoryUsage() int {
+	return loadedSize(e.root) + touchedSize(e.root)
+}
+
+// shrink brings the memory usage within the budget. Saved subtrees are
+// unloaded first. If flush is set, modified subtrees at any depth are then
+// saved, choosing the smallest one that is large enough each time, and the
+// root last, which clears the paths recorded for Pending. Lookups do not
+// set flush, so they never write to the saver.
+func (e *Editor) shrink(ctx context.Context, flush bool) error {
+	if e.budget <= 0 || e.MemoryUsage() <= e.budget {
+		return nil
+	}
+	unloadClean(e.root)
+	if !flush {
+		return nil
+	}
+	for {
+		size := e.MemoryUsage()
+		if size <= e.budget {
+			return nil
+		}
+		d := flushCandidate(e.root, size-e.budget)
+		if d == nil {
+			if e.root.ref != nil {
+				return nil
+			}
+			d = e.root
+		}
+		if err := d.SaveWithOptions(ctx, e.ls, e.o); err != nil {
+			return err
+		}
+		unloadClean(e.root)
+	}
+}
+
+// flushCandidate returns the smallest modified subtree below root whose
+// size is at least excess, or the largest one if none is that large.
+func flushCandidate(root *Node, excess int) *Node {
+	var (
+		best     *Node
+		bestSize int
+	)
+	var visit func(n *Node) int
+	visit = func(n *Node) int {
+		size := n.estimatedSize()
+		for _, k := range sortedKeys(n.forks) {
+			c := n.forks[k].Node
+			if c.forks == nil {
+				continue
+			}
+			cs := visit(c)
+			size += cs
+			if c.ref != nil {
+				continue
+			}
+			switch {
+			case best == nil:
+			case cs >= excess && (bestSize < excess || cs < bestSize):
+			case cs < excess && bestSize < excess && cs > bestSize:
+			default:
+				continue
+			}
+			best, bestSize = c, cs
+		}
+		return size
+	}
+	visit(root)
+	return best
+}
+
+// unloadClean drops the forks of every loaded node that has a reference.
+// The subtree of such a node has no modifications, so it can be loaded
+// again when needed.
+func unloadClean(n *Node) {
+	if n.forks == nil {
+		return
+	}
+	if n.ref != nil {
+		n.forks = nil
+		return
+	}
+	for _, f := range n.forks {
+		unloadClean(f.Node)
+	}
+}
+
+// touchedSize returns the size of the paths recorded on n for Pending.
+func touchedSize(n *Node) int {
+	size := 0
+	for _, t := range n.touched {
+		size += len(t)
+	}
+	return size
+}
+
+// loadedSize returns the estimated serialised size of the loaded nodes of
+// the trie rooted at n.
+func loadedSize(n *Node) int {
+	if n.forks == nil {
+		return 0
+	}
+	size := n.estimatedSize()
+	for _, f := range n.forks {
+		size += loadedSize(f.Node)
+	}
+	return size
+}
+
+// estimatedSize returns the approximate serialised size of a loaded node.
+func (n *Node) estimatedSize() int {
+	refSize := n.refBytesSize
+	if refSize == 0 {
+		refSize = len(n.entry)
+	}
+	size := nodeHeaderSize + refSize + 32
+	for _, f := range n.forks {
+		size += nodeForkPreReferenceSize + refSize
+		if len(f.Node.metadata) > 0 {
+			size += nodeForkMetadataBytesSize + 2
+			for k, v := range f.Node.metadata {
+				// quoted key and value with separators
+				size += len(k) + len(v) + 6
+			}
+		}
+	}
+	return size
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func TestEditorMemoryBudget(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	entries := make(map[string]map[string]string)
+	for i := 0; i < 200; i++ {
+		entries[fmt.Sprintf("dir%02d/file%03d", i%20, i)] = nil
+	}
+	ref := savedManifest(t, ls, entries)
+
+	const budget = 8 * 1024
+	e := mantaray.NewEditor(mantaray.NewNodeRef(ref), ls, &mantaray.EditorOptions{MemoryBudget: budget})
+	for i := 200; i < 600; i++ {
+		p := fmt.Sprintf("dir%02d/file%03d", i%20, i)
+		if err := e.Add(ctx, []byte(p), testEntry(p), nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if m := e.MemoryUsage(); m > budget {
+			t.Fatalf("expected memory usage within %d, got %d", budget, m)
+		}
+	}
+	for i := 0; i < 600; i += 3 {
+		p := fmt.Sprintf("dir%02d/file%03d", i%20, i)
+		if err := e.Remove(ctx, []byte(p)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if m := e.MemoryUsage(); m > budget {
+			t.Fatalf("expected memory usage within %d, got %d", budget, m)
+		}
+	}
+	ref, err := e.Save(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n := mantaray.NewNodeRef(ref)
+	for i := 0; i < 600; i++ {
+		p := fmt.Sprintf("dir%02d/file%03d", i%20, i)
+		entry, err := n.Lookup(ctx, []byte(p), ls)
+		if i%3 == 0 {
+			if !errors.Is(err, mantaray.ErrNotFound) {
+				t.Fatalf("expected %s to be removed, got %v", p, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("expected no error on %s, got %v", p, err)
+		}
+		if !bytes.Equal(entry, testEntry(p)) {
+			t.Fatalf("unexpected entry %x on %s", entry, p)
+		}
+	}
+}
+
+func TestEditorNoBudget(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	e := mantaray.NewEditor(mantaray.New(), ls, nil)
+	for i := 0; i < 50; i++ {
+		p := fmt.Sprintf("file%d", i)
+		if err := e.Add(ctx, []byte(p), testEntry(p), nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	before := e.MemoryUsage()
+	if _, err := e.Lookup(ctx, []byte("file1")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if e.MemoryUsage() != before {
+		t.Fatal("expected nodes to stay in memory without a budget")
+	}
+}
+
+func TestEditorFlushDepth(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	// all edits are below a single fork of the root
+	const budget = 8 * 1024
+	e := mantaray.NewEditor(mantaray.New(), ls, &mantaray.EditorOptions{MemoryBudget: budget})
+	drops := 0
+	for i := 0; i < 400; i++ {
+		p := fmt.Sprintf("d/dir%02d/f%03d", i%20, i)
+		if err := e.Add(ctx, []byte(p), testEntry(p), nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		m := e.MemoryUsage()
+		if m > budget {
+			t.Fatalf("expected memory usage within %d, got %d", budget, m)
+		}
+		if i >= 100 && m < budget/2 {
+			drops++
+		}
+	}
+	// flushing the whole fork of the root would empty the trie each time,
+	// while saving the root to clear the recorded paths is rare
+	if drops > 10 {
+		t.Fatalf("expected subtrees below the root fork to be saved, got memory usage below %d after %d edits", budget/2, drops)
+	}
+}
+
+func TestEditorLookupNoSave(t *testing.T) {
+	ctx := context.Background()
+	cs := &countingSaver{LoadSaver: newMockLoadSaver()}
+
+	root := mantaray.New()
+	for i := 0; i < 100; i++ {
+		p := fmt.Sprintf("file%03d", i)
+		if err := root.Add(ctx, []byte(p), testEntry(p), nil, cs); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	const budget = 1024
+	e := mantaray.NewEditor(root, cs, &mantaray.EditorOptions{MemoryBudget: budget})
+	if _, err := e.Lookup(ctx, []byte("file001")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cs.saves != 0 {
+		t.Fatalf("expected lookup not to save, got %d saves", cs.saves)
+	}
+	if err := e.Add(ctx, []byte("file100"), testEntry("file100"), nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cs.saves == 0 {
+		t.Fatal("expected add to save modified subtrees")
+	}
+	if m := e.MemoryUsage(); m > budget {
+		t.Fatalf("expected memory usage within %d, got %d", budget, m)
+	}
+}
+
+func TestEditorPendingPaths(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	const budget = 4 * 1024
+	e := mantaray.NewEditor(mantaray.New(), ls, &mantaray.EditorOptions{MemoryBudget: budget})
+	for i := 0; i < 5000; i++ {
+		p := fmt.Sprintf("f%04d", i)
+		if err := e.Add(ctx, []byte(p), testEntry(p), nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if m := e.MemoryUsage(); m > budget {
+			t.Fatalf("expected memory usage within %d, got %d", budget, m)
+		}
+	}
+	// the recorded paths are cleared when the root is saved early
+	if n := len(e.Root().Pending().Paths); n == 0 || n >= 5000 {
+		t.Fatalf("expected some pending paths, got %d", n)
+	}
+}
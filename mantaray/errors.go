@@ -0,0 +1,100 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"fmt"
+)
+
+// LookupError records a path that was not found in a trie.
+type LookupError struct {
+	// Op is the operation that looked up the path.
+	Op string
+	// Path is the full path looked up.
+	Path []byte
+	// Ref is the reference of the deepest node reached, if saved.
+	Ref []byte
+	// Err is the cause, usually ErrNotFound.
+	Err error
+}
+
+func (e *LookupError) Error() string {
+	return fmt.Sprintf("%s entry on '%s' ('%x'): %v", e.Op, e.Path, e.Path, e.Err)
+}
+
+// Unwrap returns the cause of the failure.
+func (e *LookupError) Unwrap() error {
+	return e.Err
+}
+
+// LoadError records a node of a trie that could not be loaded.
+type LoadError struct {
+	// Op is the operation that loaded the node.
+	Op string
+	// Path is the path of the node from the root of the trie.
+	Path []byte
+	// Ref is the reference of the node.
+	Ref []byte
+	// Err is the cause, such as ErrNoLoader, a *DecodeError or the error
+	// of the Loader.
+	Err error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("%s: load node %x on '%s': %v", e.Op, e.Ref, e.Path, e.Err)
+}
+
+// Unwrap returns the cause of the failure.
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// SaveError records a node of a trie that could not be saved.
+type SaveError struct {
+	// Op is the operation that saved the node.
+	Op string
+	// Path is the path of the node from the root of the trie.
+	Path []byte
+	// Ref is the reference of the node, if known, such as one returned by
+	// the Saver along with its error.
+	Ref []byte
+	// Err is the cause, such as ErrNoSaver, ErrInvalid or the error of the
+	// Saver.
+	Err error
+}
+
+func (e *SaveError) Error() string {
+	if e.Ref != nil {
+		return fmt.Sprintf("%s: save node %x on '%s': %v", e.Op, e.Ref, e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s: save node on '%s': %v", e.Op, e.Path, e.Err)
+}
+
+// Unwrap returns the cause of the failure.
+func (e *SaveError) Unwrap() error {
+	return e.Err
+}
+
+// prependPath prepends prefix to the path of an error returned from the
+// subtree at prefix, so that the path is complete once the error reaches
+// the root. The error is copied rather than modified, as it may be shared.
+// Other errors are returned unchanged.
+func prependPath(prefix []byte, err error) error {
+	switch e := err.(type) {
+	case *LookupError:
+		c := *e
+		c.Path = appendPath(prefix, e.Path)
+		return &c
+	case *LoadError:
+		c := *e
+		c.Path = appendPath(prefix, e.Path)
+		return &c
+	case *SaveError:
+		c := *e
+		c.Path = appendPath(prefix, e.Path)
+		return &c
+	}
+	return err
+}
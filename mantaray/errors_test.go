@@ -0,0 +1,165 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func TestLookupError(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	ref := savedManifest(t, ls, map[string]map[string]string{
+		"img/1.png": nil,
+		"img/2.png": nil,
+	})
+
+	n := mantaray.NewNodeRef(ref)
+	_, err := n.Lookup(ctx, []byte("img/3.png"), ls)
+	var le *mantaray.LookupError
+	if !errors.As(err, &le) {
+		t.Fatalf("expected lookup error, got %v", err)
+	}
+	if le.Op != "lookup" || string(le.Path) != "img/3.png" || le.Ref == nil {
+		t.Fatalf("unexpected lookup error %+v", le)
+	}
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	err = n.Remove(ctx, []byte("img/3.png"), ls)
+	if !errors.As(err, &le) {
+		t.Fatalf("expected lookup error, got %v", err)
+	}
+	if le.Op != "remove" || string(le.Path) != "img/3.png" {
+		t.Fatalf("unexpected lookup error %+v", le)
+	}
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	ref := savedManifest(t, ls, map[string]map[string]string{
+		"img/1.png":  nil,
+		"img/2.png":  nil,
+		"index.html": nil,
+	})
+	img, err := mantaray.NewNodeRef(ref).LookupNode(ctx, []byte("img/"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	imgRef := img.Reference()
+
+	t.Run("no loader", func(t *testing.T) {
+		_, err := mantaray.NewNodeRef(ref).Lookup(ctx, []byte("index.html"), nil)
+		var le *mantaray.LoadError
+		if !errors.As(err, &le) {
+			t.Fatalf("expected load error, got %v", err)
+		}
+		if le.Op != "lookup" || len(le.Path) != 0 || !bytes.Equal(le.Ref, ref) {
+			t.Fatalf("unexpected load error %+v", le)
+		}
+		if !errors.Is(err, mantaray.ErrNoLoader) {
+			t.Fatalf("expected no loader error, got %v", err)
+		}
+	})
+
+	// replace the node of the directory with invalid data
+	var a addr
+	copy(a[:], imgRef)
+	ls.store[a] = bytes.Repeat([]byte{1}, 128)
+
+	for _, tc := range []struct {
+		op string
+		f  func(*mantaray.Node) error
+	}{
+		{"lookup", func(n *mantaray.Node) error {
+			_, err := n.Lookup(ctx, []byte("img/1.png"), ls)
+			return err
+		}},
+		{"add", func(n *mantaray.Node) error {
+			return n.Add(ctx, []byte("img/3.png"), testEntry("img/3.png"), nil, ls)
+		}},
+		{"remove", func(n *mantaray.Node) error {
+			return n.Remove(ctx, []byte("img/1.png"), ls)
+		}},
+		{"walk", func(n *mantaray.Node) error {
+			return n.Walk(ctx, []byte{}, ls, func(_ []byte, _ bool, err error) error {
+				return err
+			})
+		}},
+	} {
+		t.Run(tc.op, func(t *testing.T) {
+			err := tc.f(mantaray.NewNodeRef(ref))
+			var le *mantaray.LoadError
+			if !errors.As(err, &le) {
+				t.Fatalf("expected load error, got %v", err)
+			}
+			if string(le.Path) != "img/" || !bytes.Equal(le.Ref, imgRef) {
+				t.Fatalf("unexpected load error %+v", le)
+			}
+			if le.Op != tc.op {
+				t.Fatalf("expected operation %s, got %s", tc.op, le.Op)
+			}
+			var de *mantaray.DecodeError
+			if !errors.As(err, &de) || !errors.Is(err, mantaray.ErrInvalid) {
+				t.Fatalf("expected invalid input error, got %v", err)
+			}
+		})
+	}
+}
+
+// unconfirmedSaver stores the data but fails to confirm the save.
+type unconfirmedSaver struct {
+	mantaray.LoadSaver
+}
+
+var errUnconfirmed = errors.New("save not confirmed")
+
+func (s unconfirmedSaver) Save(ctx context.Context, b []byte) ([]byte, error) {
+	ref, err := s.LoadSaver.Save(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	return ref, errUnconfirmed
+}
+
+func TestSaveError(t *testing.T) {
+	ctx := context.Background()
+	err := newTestTrie(t).Save(ctx, nil)
+	var se *mantaray.SaveError
+	if !errors.As(err, &se) || !errors.Is(err, mantaray.ErrNoSaver) {
+		t.Fatalf("expected no saver error, got %v", err)
+	}
+
+	err = newTestTrie(t).Save(ctx, &limitedSaver{LoadSaver: newMockLoadSaver(), failAfter: 5})
+	if !errors.As(err, &se) || !errors.Is(err, errSaveFailed) {
+		t.Fatalf("expected save error, got %v", err)
+	}
+	if se.Op != "save" || !strings.HasPrefix(string(se.Path), "dir") {
+		t.Fatalf("unexpected save error %+v", se)
+	}
+
+	err = newTestTrie(t).Save(ctx, unconfirmedSaver{newMockLoadSaver()})
+	if !errors.As(err, &se) || !errors.Is(err, errUnconfirmed) {
+		t.Fatalf("expected unconfirmed save error, got %v", err)
+	}
+	if len(se.Ref) == 0 || len(se.Path) == 0 {
+		t.Fatalf("expected reference and path of the node, got %+v", se)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("%x on '%s'", se.Ref, se.Path)) {
+		t.Fatalf("expected reference in error %q", err)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build gofuzz
+// +build gofuzz
+
+package mantaray
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Fuzz is the go-fuzz entry point for UnmarshalBinary, seeded with
+// testdata/corpus. Decoding must never panic, must fail with a
+// *DecodeError, and a decoded node must serialise again.
+func Fuzz(data []byte) int {
+	n := &Node{}
+	if err := n.UnmarshalBinary(data); err != nil {
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			panic(fmt.Sprintf("untyped decode error: %v", err))
+		}
+		return 0
+	}
+	// escaping may grow metadata past the limit
+	if _, err := n.MarshalBinary(); err != nil && !errors.Is(err, ErrMetadataTooLarge) {
+		panic(fmt.Sprintf("decoded node does not serialise: %v", err))
+	}
+	return 1
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"sync"
+)
+
+// Manifest is a trie that is safe for concurrent use. Lookups and walks
+// run concurrently and share the lazy loads of the nodes they visit, while
+// edits and saves run one at a time.
+type Manifest struct {
+	ls LoadSaver
+
+	mu   sync.RWMutex // held for reading by lookups and walks
+	root *Node
+
+	// loads publish the loaded fields of a node under lmu, so that readers
+	// holding mu for reading see them once the node is loaded
+	lmu     sync.Mutex
+	loads   map[*Node]*nodeLoad // loads in progress
+	loading sync.WaitGroup      // waited for by edits and saves
+}
+
+// nodeLoad is a load of a node shared by concurrent readers.
+type nodeLoad struct {
+	done chan struct{} // closed when the load completes
+	err  error
+}
+
+// NewManifest returns a manifest of the trie rooted at root. The caller
+// must not use root directly afterwards.
+func NewManifest(root *Node, ls LoadSaver) *Manifest {
+	return &Manifest{
+		ls:    ls,
+		root:  root,
+		loads: make(map[*Node]*nodeLoad),
+	}
+}
+
+// Reference returns the address of the manifest root if saved.
+func (m *Manifest) Reference() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyBytes(m.root.ref)
+}
+
+// Lookup finds the entry for a path or returns error if not found.
+func (m *Manifest) Lookup(ctx context.Context, path []byte) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, err := m.lookupNode(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return copyBytes(n.entry), nil
+}
+
+// Metadata returns a copy of the metadata of the node at path.
+func (m *Manifest) Metadata(ctx context.Context, path []byte) (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, err := m.lookupNode(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	// nodes are shared by readers, so loaded metadata is not kept
+	metadata, err := loadMetadata(ctx, m.ls, n.metadata)
+	if err != nil {
+		return nil, &LoadError{Op: "lookup", Path: copyBytes(path), Ref: n.ref, Err: err}
+	}
+	return copyMetadata(metadata), nil
+}
+
+// Walk is like Node.Walk. It holds the manifest for reading until it
+// returns, so walkFn must not call any method of the manifest: an edit
+// would never proceed, and a lookup would wait for edits blocked by Walk.
+func (m *Manifest) Walk(ctx context.Context, root []byte, walkFn WalkFunc) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, err := m.lookupNode(ctx, root)
+	if err != nil {
+		return walkFn(root, false, err)
+	}
+	return walk(ctx, root, []byte{}, m.ls, m, n, walkFn)
+}
+
+// Add adds an entry to the path.
+func (m *Manifest) Add(ctx context.Context, path, entry []byte, metadata map[string]string) error {
+	m.lock()
+	defer m.mu.Unlock()
+	return m.root.Add(ctx, path, entry, metadata, m.ls)
+}
+
+// Remove removes the entry at path.
+func (m *Manifest) Remove(ctx context.Context, path []byte) error {
+	m.lock()
+	defer m.mu.Unlock()
+	return m.root.Remove(ctx, path, m.ls)
+}
+
+// Apply applies the patch like Node.Apply.
+func (m *Manifest) Apply(ctx context.Context, p *Patch) error {
+	m.lock()
+	defer m.mu.Unlock()
+	return m.root.Apply(ctx, p, m.ls)
+}
+
+// ApplyOps applies the operations like Node.ApplyOps.
+func (m *Manifest) ApplyOps(ctx context.Context, ops []Op) error {
+	m.lock()
+	defer m.mu.Unlock()
+	return m.root.ApplyOps(ctx, ops, m.ls)
+}
+
+// Save saves the manifest and returns the reference of its root.
+func (m *Manifest) Save(ctx context.Context) ([]byte, error) {
+	m.lock()
+	defer m.mu.Unlock()
+	if err := m.root.Save(ctx, m.ls); err != nil {
+		return nil, err
+	}
+	return copyBytes(m.root.ref), nil
+}
+
+// lookupNode is LookupNode loading nodes through m. It is called with mu
+// held for reading.
+func (m *Manifest) lookupNode(ctx context.Context, path []byte) (*Node, error) {
+	n, rest := m.root, path
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if err := m.load(ctx, n, m.ls); err != nil {
+			return nil, &LoadError{Op: "lookup", Path: copyBytes(path[:len(path)-len(rest)]), Ref: n.ref, Err: err}
+		}
+		if len(rest) == 0 {
+			return n, nil
+		}
+		f := n.forks[rest[0]]
+		if f == nil {
+			return nil, notFound("lookup", path, n.ref)
+		}
+		c := common(f.prefix, rest)
+		if len(c) != len(f.prefix) {
+			return nil, notFound("lookup", path, n.ref)
+		}
+		n, rest = f.Node, rest[len(c):]
+	}
+}
+
+// lock locks mu for writing once the loads left running by readers that
+// stopped waiting for them are complete.
+func (m *Manifest) lock() {
+	m.mu.Lock()
+	m.loading.Wait()
+}
+
+// load loads n once for all concurrent readers. The load is not canceled
+// with the context of any reader, and each reader stops waiting for it when
+// its own context is done. It implements nodeLoader.
+func (m *Manifest) load(ctx context.Context, n *Node, l Loader) error {
+	// nodes without a reference are never loaded lazily, and the
+	// reference of a node does not change while mu is held for reading
+	if n.ref == nil {
+		return nil
+	}
+	m.lmu.Lock()
+	if n.forks != nil {
+		m.lmu.Unlock()
+		return nil
+	}
+	nl, ok := m.loads[n]
+	if !ok {
+		nl = &nodeLoad{done: make(chan struct{})}
+		m.loads[n] = nl
+		m.loading.Add(1)
+		go m.loadNode(detach(ctx), n, l, nl)
+	}
+	m.lmu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-nl.done:
+		return nl.err
+	}
+}
+
+// loadNode runs the load nl of n and publishes its result.
+func (m *Manifest) loadNode(ctx context.Context, n *Node, l Loader, nl *nodeLoad) {
+	defer m.loading.Done()
+	c := &Node{ref: n.ref}
+	err := c.load(ctx, l)
+	m.lmu.Lock()
+	if err == nil {
+		n.obfuscationKey = c.obfuscationKey
+		n.refBytesSize = c.refBytesSize
+		n.entry = c.entry
+		n.forks = c.forks
+	}
+	nl.err = err
+	delete(m.loads, n)
+	m.lmu.Unlock()
+	close(nl.done)
+}
+
+// prefetch implements nodeLoader.
+func (m *Manifest) prefetch(context.Context, *Node, Loader) error {
+	return nil
+}
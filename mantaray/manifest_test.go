@@ -0,0 +1,218 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+// loadCountingLoadSaver counts the loads of each reference.
+type loadCountingLoadSaver struct {
+	mantaray.LoadSaver
+	delay time.Duration
+
+	mu    sync.Mutex
+	loads map[string]int
+}
+
+func (ls *loadCountingLoadSaver) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	ls.mu.Lock()
+	ls.loads[string(ref)]++
+	ls.mu.Unlock()
+	time.Sleep(ls.delay)
+	return ls.LoadSaver.Load(ctx, ref)
+}
+
+func TestManifestSharedLoads(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	ref := savedManifest(t, ls, map[string]map[string]string{
+		"img/1.png":  nil,
+		"img/2.png":  nil,
+		"index.html": nil,
+	})
+
+	cls := &loadCountingLoadSaver{LoadSaver: ls, delay: 10 * time.Millisecond, loads: make(map[string]int)}
+	m := mantaray.NewManifest(mantaray.NewNodeRef(ref), cls)
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entry, err := m.Lookup(ctx, []byte("img/2.png"))
+			if err == nil && !bytes.Equal(entry, testEntry("img/2.png")) {
+				err = fmt.Errorf("unexpected entry %x", entry)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	for r, n := range cls.loads {
+		if n != 1 {
+			t.Fatalf("expected reference %x to be loaded once, got %d", r, n)
+		}
+	}
+}
+
+func TestManifestConcurrent(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	entries := make(map[string]map[string]string)
+	for i := 0; i < 100; i++ {
+		entries[fmt.Sprintf("dir%d/file%02d", i%5, i)] = map[string]string{"index": fmt.Sprint(i)}
+	}
+	ref := savedManifest(t, ls, entries)
+	m := mantaray.NewManifest(mantaray.NewNodeRef(ref), ls)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	run := func(f func(i int) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if err := f(i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	for r := 0; r < 8; r++ {
+		// the first 50 entries are never edited
+		run(func(i int) error {
+			p := fmt.Sprintf("dir%d/file%02d", i%50%5, i%50)
+			entry, err := m.Lookup(ctx, []byte(p))
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(entry, testEntry(p)) {
+				return fmt.Errorf("unexpected entry %x on %s", entry, p)
+			}
+			md, err := m.Metadata(ctx, []byte(p))
+			if err != nil {
+				return err
+			}
+			if md["index"] != fmt.Sprint(i%50) {
+				return fmt.Errorf("unexpected metadata %v on %s", md, p)
+			}
+			return nil
+		})
+	}
+	run(func(i int) error {
+		return m.Walk(ctx, []byte{}, func(_ []byte, _ bool, err error) error {
+			return err
+		})
+	})
+	run(func(i int) error {
+		p := fmt.Sprintf("dir%d/file%02d", i%5, 50+i%50)
+		if i%2 == 0 {
+			err := m.Remove(ctx, []byte(p))
+			if errors.Is(err, mantaray.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		return m.Add(ctx, []byte(p), testEntry(p), nil)
+	})
+	run(func(i int) error {
+		p := fmt.Sprintf("new/file%02d", i)
+		return m.Add(ctx, []byte(p), testEntry(p), nil)
+	})
+	run(func(i int) error {
+		if i%10 != 0 {
+			return nil
+		}
+		_, err := m.Save(ctx)
+		return err
+	})
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	ref, err := m.Save(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(m.Reference(), ref) {
+		t.Fatalf("expected reference %x, got %x", ref, m.Reference())
+	}
+	n := mantaray.NewNodeRef(ref)
+	for i := 0; i < 100; i++ {
+		p := fmt.Sprintf("new/file%02d", i)
+		if _, err := n.Lookup(ctx, []byte(p), ls); err != nil {
+			t.Fatalf("expected no error on %s, got %v", p, err)
+		}
+	}
+}
+
+func TestManifestCanceledLoad(t *testing.T) {
+	ls := newMockLoadSaver()
+	ref := savedManifest(t, ls, map[string]map[string]string{
+		"img/1.png":  nil,
+		"index.html": nil,
+	})
+
+	bl := &blockingLoader{Loader: ls, release: make(chan struct{})}
+	m := mantaray.NewManifest(mantaray.NewNodeRef(ref), struct {
+		mantaray.Loader
+		mantaray.Saver
+	}{bl, ls})
+
+	// the first reader starts the shared load of the root and gives up on it
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := m.Lookup(ctx, []byte("index.html"))
+		canceled <- err
+	}()
+	done := make(chan error)
+	go func() {
+		_, err := m.Lookup(context.Background(), []byte("index.html"))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+
+	// edits wait for the load left running by the first reader
+	added := make(chan error)
+	go func() {
+		added <- m.Add(context.Background(), []byte("img/2.png"), testEntry("img/2.png"), nil)
+	}()
+	close(bl.release)
+	if err := <-done; err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := <-added; err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	entry, err := m.Lookup(context.Background(), []byte("img/2.png"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(entry, testEntry("img/2.png")) {
+		t.Fatalf("unexpected entry %x", entry)
+	}
+}
@@ -81,8 +81,14 @@ var (
 	ErrInvalid = errors.New("input invalid")
 	// ErrForkIvalid shows embedded node on a fork has no reference
 	ErrForkIvalid = errors.New("fork node without reference")
+	// ErrNodeTooLarge serialised node exceeds the maximum node size
+	ErrNodeTooLarge = errors.New("node too large")
 )
 
+// ChunkSize is the payload size of a Swarm chunk, the largest node size
+// that can be stored in a single chunk.
+const ChunkSize = 4096
+
 var obfuscationKeyFn = func(p []byte) (n int, err error) {
 	return rand.Read(p)
 }
@@ -97,6 +103,12 @@ func SetObfuscationKeyFn(fn func([]byte) (int, error)) {
 
 // MarshalBinary serialises the node
 func (n *Node) MarshalBinary() (bytes []byte, err error) {
+	return n.marshalBinary(0)
+}
+
+// marshalBinary serialises the node, failing with ErrNodeTooLarge if the
+// result exceeds maxSize. A maxSize of 0 disables the check.
+func (n *Node) marshalBinary(maxSize int) (bytes []byte, err error) {
 	if n.forks == nil {
 		return nil, ErrInvalid
 	}
@@ -168,6 +180,10 @@ func (n *Node) MarshalBinary() (bytes []byte, err error) {
 		copy(xorEncryptedBytes[i:end], encrypted)
 	}
 
+	if maxSize > 0 && len(xorEncryptedBytes) > maxSize {
+		return nil, fmt.Errorf("%d bytes exceed %d: %w", len(xorEncryptedBytes), maxSize, ErrNodeTooLarge)
+	}
+
 	return xorEncryptedBytes, nil
 }
 
@@ -211,13 +227,40 @@ func (bb *bitsForBytes) iter(f func(byte) error) error {
 	}
 }
 
-// UnmarshalBinary deserialises a node
+// DecodeError is the failure to deserialise a node.
+type DecodeError struct {
+	// Offset is the position in the input where the field starts.
+	Offset int
+	// Field names the part of the node that could not be decoded.
+	Field string
+	// Fork is the byte of the fork being decoded, if InFork is set.
+	Fork   byte
+	InFork bool
+	// Err is the cause, matching ErrTooShort or ErrInvalid.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	if e.InFork {
+		return fmt.Sprintf("decode %s at offset %d on byte '%x': %v", e.Field, e.Offset, []byte{e.Fork}, e.Err)
+	}
+	return fmt.Sprintf("decode %s at offset %d: %v", e.Field, e.Offset, e.Err)
+}
+
+// Unwrap returns the cause of the failure.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// UnmarshalBinary deserialises a node. Malformed input, including
+// trailing data, is rejected with a *DecodeError and leaves the node
+// unchanged.
 func (n *Node) UnmarshalBinary(data []byte) error {
 	if len(data) < nodeHeaderSize {
-		return ErrTooShort
+		return &DecodeError{Offset: 0, Field: "header", Err: ErrTooShort}
 	}
 
-	n.obfuscationKey = append([]byte{}, data[0:nodeObfuscationKeySize]...)
+	obfuscationKey := append([]byte{}, data[0:nodeObfuscationKeySize]...)
 
 	// perform XOR decryption on bytes after obfuscation key
 	xorDecryptedBytes := make([]byte, len(data))
@@ -230,7 +273,7 @@ func (n *Node) UnmarshalBinary(data []byte) error {
 			end = len(data)
 		}
 
-		decrypted := encryptDecrypt(data[i:end], n.obfuscationKey)
+		decrypted := encryptDecrypt(data[i:end], obfuscationKey)
 		copy(xorDecryptedBytes[i:end], decrypted)
 	}
 
@@ -239,129 +282,116 @@ func (n *Node) UnmarshalBinary(data []byte) error {
 	// Verify version hash.
 	versionHash := data[nodeObfuscationKeySize : nodeObfuscationKeySize+versionHashSize]
 
-	if bytes.Equal(versionHash, version01HashBytes) {
-
-		refBytesSize := int(data[nodeHeaderSize-1])
-
-		n.entry = append([]byte{}, data[nodeHeaderSize:nodeHeaderSize+refBytesSize]...)
-		offset := nodeHeaderSize + refBytesSize // skip entry
-		n.forks = make(map[byte]*fork)
-		bb := &bitsForBytes{}
-		bb.fromBytes(data[offset:])
-		offset += 32 // skip forks
-		return bb.iter(func(b byte) error {
-			f := &fork{}
-
-			if len(data) < offset+nodeForkPreReferenceSize+refBytesSize {
-				err := fmt.Errorf("not enough bytes for node fork: %d (%d)", (len(data) - offset), (nodeForkPreReferenceSize + refBytesSize))
-				return fmt.Errorf("%w on byte '%x'", err, []byte{b})
-			}
-
-			err := f.fromBytes(data[offset : offset+nodeForkPreReferenceSize+refBytesSize])
-			if err != nil {
-				return fmt.Errorf("%w on byte '%x'", err, []byte{b})
-			}
-
-			n.forks[b] = f
-			offset += nodeForkPreReferenceSize + refBytesSize
-			return nil
-		})
-	} else if bytes.Equal(versionHash, version02HashBytes) {
-
-		refBytesSize := int(data[nodeHeaderSize-1])
-
-		n.entry = append([]byte{}, data[nodeHeaderSize:nodeHeaderSize+refBytesSize]...)
-		offset := nodeHeaderSize + refBytesSize // skip entry
-		n.forks = make(map[byte]*fork)
-		bb := &bitsForBytes{}
-		bb.fromBytes(data[offset:])
-		offset += 32 // skip forks
-		return bb.iter(func(b byte) error {
-			f := &fork{}
-
-			if len(data) < offset+nodeForkTypeBytesSize {
-				return fmt.Errorf("not enough bytes for node fork: %d (%d) on byte '%x'", (len(data) - offset), (nodeForkTypeBytesSize), []byte{b})
-			}
-
-			nodeType := uint8(data[offset])
-
-			nodeForkSize := nodeForkPreReferenceSize + refBytesSize
-
-			if nodeTypeIsWithMetadataType(nodeType) {
-				if len(data) < offset+nodeForkPreReferenceSize+refBytesSize+nodeForkMetadataBytesSize {
-					return fmt.Errorf("not enough bytes for node fork: %d (%d) on byte '%x'", (len(data) - offset), (nodeForkPreReferenceSize + refBytesSize + nodeForkMetadataBytesSize), []byte{b})
-				}
-
-				metadataBytesSize := binary.BigEndian.Uint16(data[offset+nodeForkSize : offset+nodeForkSize+nodeForkMetadataBytesSize])
-
-				nodeForkSize += nodeForkMetadataBytesSize
-				nodeForkSize += int(metadataBytesSize)
+	var withMetadata bool
+	switch {
+	case bytes.Equal(versionHash, version01HashBytes):
+	case bytes.Equal(versionHash, version02HashBytes):
+		withMetadata = true
+	default:
+		return &DecodeError{
+			Offset: nodeObfuscationKeySize,
+			Field:  "version hash",
+			Err:    fmt.Errorf("%w: unknown version hash %x", ErrInvalid, versionHash),
+		}
+	}
 
-				err := f.fromBytes02(data[offset:offset+nodeForkSize], refBytesSize, int(metadataBytesSize))
-				if err != nil {
-					return fmt.Errorf("%w on byte '%x'", err, []byte{b})
-				}
-			} else {
-				if len(data) < offset+nodeForkPreReferenceSize+refBytesSize {
-					return fmt.Errorf("not enough bytes for node fork: %d (%d) on byte '%x'", (len(data) - offset), (nodeForkPreReferenceSize + refBytesSize), []byte{b})
-				}
+	refBytesSize := int(data[nodeHeaderSize-1])
+	offset := nodeHeaderSize
+	if len(data) < offset+refBytesSize {
+		return &DecodeError{Offset: offset, Field: "entry", Err: ErrTooShort}
+	}
+	entry := append([]byte{}, data[offset:offset+refBytesSize]...)
+	offset += refBytesSize // skip entry
 
-				err := f.fromBytes(data[offset : offset+nodeForkSize])
-				if err != nil {
-					return fmt.Errorf("%w on byte '%x'", err, []byte{b})
-				}
-			}
+	if len(data) < offset+32 {
+		return &DecodeError{Offset: offset, Field: "fork index", Err: ErrTooShort}
+	}
+	bb := &bitsForBytes{}
+	bb.fromBytes(data[offset : offset+32])
+	offset += 32 // skip forks
 
-			n.forks[b] = f
-			offset += nodeForkSize
-			return nil
-		})
+	forks := make(map[byte]*fork)
+	err := bb.iter(func(b byte) error {
+		f, size, err := decodeFork(data, offset, refBytesSize, withMetadata)
+		if err != nil {
+			err.Fork = b
+			err.InFork = true
+			return err
+		}
+		forks[b] = f
+		offset += size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if offset != len(data) {
+		return &DecodeError{
+			Offset: offset,
+			Field:  "end",
+			Err:    fmt.Errorf("%w: %d bytes of trailing data", ErrInvalid, len(data)-offset),
+		}
 	}
 
-	return fmt.Errorf("invalid version hash %x", versionHash)
+	n.obfuscationKey = obfuscationKey
+	n.refBytesSize = refBytesSize
+	n.entry = entry
+	n.forks = forks
+	return nil
 }
 
-func (f *fork) fromBytes(b []byte) error {
-	nodeType := uint8(b[0])
-	prefixLen := int(uint8(b[1]))
+// decodeFork decodes the fork starting at offset in the decrypted data
+// and returns it with its size.
+func decodeFork(data []byte, offset, refBytesSize int, withMetadata bool) (*fork, int, *DecodeError) {
+	size := nodeForkPreReferenceSize + refBytesSize
+	if len(data) < offset+size {
+		return nil, 0, &DecodeError{Offset: offset, Field: "fork", Err: ErrTooShort}
+	}
 
+	nodeType := data[offset]
+	prefixLen := int(data[offset+nodeForkTypeBytesSize])
 	if prefixLen == 0 || prefixLen > nodePrefixMaxSize {
-		return fmt.Errorf("invalid prefix length: %d", prefixLen)
+		return nil, 0, &DecodeError{
+			Offset: offset + nodeForkTypeBytesSize,
+			Field:  "fork prefix length",
+			Err:    fmt.Errorf("%w: prefix length %d", ErrInvalid, prefixLen),
+		}
 	}
 
-	f.prefix = b[nodeForkHeaderSize : nodeForkHeaderSize+prefixLen]
-	f.Node = NewNodeRef(b[nodeForkPreReferenceSize:])
+	f := &fork{
+		prefix: data[offset+nodeForkHeaderSize : offset+nodeForkHeaderSize+prefixLen],
+		Node:   NewNodeRef(data[offset+nodeForkPreReferenceSize : offset+size]),
+	}
 	f.Node.nodeType = nodeType
 
-	return nil
-}
-
-func (f *fork) fromBytes02(b []byte, refBytesSize, metadataBytesSize int) error {
-	nodeType := uint8(b[0])
-	prefixLen := int(uint8(b[1]))
-
-	if prefixLen == 0 || prefixLen > nodePrefixMaxSize {
-		return fmt.Errorf("invalid prefix length: %d", prefixLen)
+	if !withMetadata || !nodeTypeIsWithMetadataType(nodeType) {
+		return f, size, nil
 	}
 
-	f.prefix = b[nodeForkHeaderSize : nodeForkHeaderSize+prefixLen]
-	f.Node = NewNodeRef(b[nodeForkPreReferenceSize : nodeForkPreReferenceSize+refBytesSize])
-	f.Node.nodeType = nodeType
+	if len(data) < offset+size+nodeForkMetadataBytesSize {
+		return nil, 0, &DecodeError{Offset: offset + size, Field: "fork metadata size", Err: ErrTooShort}
+	}
+	metadataBytesSize := int(binary.BigEndian.Uint16(data[offset+size : offset+size+nodeForkMetadataBytesSize]))
+	size += nodeForkMetadataBytesSize
 
+	if len(data) < offset+size+metadataBytesSize {
+		return nil, 0, &DecodeError{Offset: offset + size, Field: "fork metadata", Err: ErrTooShort}
+	}
 	if metadataBytesSize > 0 {
-		metadataBytes := b[nodeForkPreReferenceSize+refBytesSize+nodeForkMetadataBytesSize:]
-
 		metadata := make(map[string]string)
 		// using JSON encoding for metadata
-		err := json.Unmarshal(metadataBytes, &metadata)
-		if err != nil {
-			return err
+		if err := json.Unmarshal(data[offset+size:offset+size+metadataBytesSize], &metadata); err != nil {
+			return nil, 0, &DecodeError{
+				Offset: offset + size,
+				Field:  "fork metadata",
+				Err:    fmt.Errorf("%w: %v", ErrInvalid, err),
+			}
 		}
-
 		f.Node.metadata = metadata
 	}
+	size += metadataBytesSize
 
-	return nil
+	return f, size, nil
 }
 
 func (f *fork) bytes() (b []byte, err error) {
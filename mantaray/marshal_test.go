@@ -8,7 +8,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
+	"io/ioutil"
 	mrand "math/rand"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -195,3 +198,106 @@ func TestMarshal(t *testing.T) {
 	// 	}
 	// }
 }
+
+// unmarshalNoPanic decodes data, failing the test if the decoder panics or
+// returns an untyped error.
+func unmarshalNoPanic(t *testing.T, name string, data []byte) error {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("%s: decoding %x panicked: %v", name, data, r)
+		}
+	}()
+	err := (&Node{}).UnmarshalBinary(data)
+	var de *DecodeError
+	if err != nil && !errors.As(err, &de) {
+		t.Fatalf("%s: expected decode error, got %v", name, err)
+	}
+	return err
+}
+
+func TestUnmarshalCorpus(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "corpus", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("expected corpus files")
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Base(file)
+		if unmarshalNoPanic(t, name, data) != nil {
+			continue
+		}
+		// every truncation and extension of a valid node is rejected
+		for i := 0; i < len(data); i++ {
+			if unmarshalNoPanic(t, name, data[:i]) == nil {
+				t.Fatalf("%s: expected error decoding %d of %d bytes", name, i, len(data))
+			}
+		}
+		if unmarshalNoPanic(t, name, append(data[:len(data):len(data)], 0)) == nil {
+			t.Fatalf("%s: expected error decoding trailing data", name)
+		}
+		for i := range data {
+			mutated := append([]byte(nil), data...)
+			mutated[i] ^= 0xff
+			_ = unmarshalNoPanic(t, name, mutated)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	n := New()
+	n.SetObfuscationKey(ZeroObfuscationKey)
+	if err := n.Add(context.Background(), []byte("a"), make([]byte, 32), nil, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer func(r func(*fork) []byte) { refBytes = r }(refBytes)
+	refBytes = func(*fork) []byte {
+		return make([]byte, 32)
+	}
+	data, err := n.MarshalBinary()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	forkOffset := nodeHeaderSize + 32 + 32
+	badPrefix := append([]byte(nil), data...)
+	badPrefix[forkOffset+1] = nodePrefixMaxSize + 1
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		err    error
+		field  string
+		offset int
+		inFork bool
+	}{
+		{"empty", nil, ErrTooShort, "header", 0, false},
+		{"no entry", data[:nodeHeaderSize+1], ErrTooShort, "entry", nodeHeaderSize, false},
+		{"no fork index", data[:forkOffset-1], ErrTooShort, "fork index", nodeHeaderSize + 32, false},
+		{"short fork", data[:forkOffset+1], ErrTooShort, "fork", forkOffset, true},
+		{"prefix length", badPrefix, ErrInvalid, "fork prefix length", forkOffset + 1, true},
+		{"trailing data", append(data[:len(data):len(data)], 0), ErrInvalid, "end", len(data), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := (&Node{}).UnmarshalBinary(tc.data)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			var de *DecodeError
+			if !errors.As(err, &de) {
+				t.Fatalf("expected decode error, got %v", err)
+			}
+			if de.Field != tc.field || de.Offset != tc.offset || de.InFork != tc.inFork {
+				t.Fatalf("expected field %s at offset %d, got %v", tc.field, tc.offset, de)
+			}
+			if tc.inFork && de.Fork != 'a' {
+				t.Fatalf("expected fork 'a', got %q", de.Fork)
+			}
+		})
+	}
+}
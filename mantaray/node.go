@@ -39,6 +39,7 @@ type Node struct {
 	entry          []byte
 	metadata       map[string]string
 	forks          map[byte]*fork
+	touched        [][]byte // paths changed on the node since it was saved
 }
 
 type fork struct {
@@ -69,8 +70,10 @@ func New() *Node {
 	return &Node{forks: make(map[byte]*fork)}
 }
 
-func notFound(path []byte) error {
-	return fmt.Errorf("entry on '%s' ('%x'): %w", path, path, ErrNotFound)
+// notFound returns the error of an operation not finding path below the
+// node with reference ref.
+func notFound(op string, path, ref []byte) error {
+	return &LookupError{Op: op, Path: copyBytes(path), Ref: ref, Err: ErrNotFound}
 }
 
 // IsValueType returns true if the node contains entry.
@@ -109,7 +112,6 @@ func (n *Node) makeWithMetadata() {
 	n.nodeType = n.nodeType | nodeTypeWithMetadata
 }
 
-//nolint,unused
 func (n *Node) makeNotValue() {
 	n.nodeType = (nodeTypeMask ^ nodeTypeValue) & n.nodeType
 }
@@ -123,7 +125,6 @@ func (n *Node) makeNotWithPathSeparator() {
 	n.nodeType = (nodeTypeMask ^ nodeTypeWithPathSeparator) & n.nodeType
 }
 
-//nolint,unused
 func (n *Node) makeNotWithMetadata() {
 	n.nodeType = (nodeTypeMask ^ nodeTypeWithMetadata) & n.nodeType
 }
@@ -151,28 +152,34 @@ func (n *Node) Metadata() map[string]string {
 
 // LookupNode finds the node for a path or returns error if not found
 func (n *Node) LookupNode(ctx context.Context, path []byte, l Loader) (*Node, error) {
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	default:
-	}
-	if n.forks == nil {
-		if err := n.load(ctx, l); err != nil {
-			return nil, err
+	node, rest := n, path
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
 		}
+		if node.forks == nil {
+			if err := node.load(ctx, l); err != nil {
+				return nil, &LoadError{Op: "lookup", Path: copyBytes(path[:len(path)-len(rest)]), Ref: node.ref, Err: err}
+			}
+		}
+		if len(rest) == 0 {
+			if err := node.resolveMetadata(ctx, l); err != nil {
+				return nil, &LoadError{Op: "lookup", Path: copyBytes(path), Ref: node.ref, Err: err}
+			}
+			return node, nil
+		}
+		f := node.forks[rest[0]]
+		if f == nil {
+			return nil, notFound("lookup", path, node.ref)
+		}
+		c := common(f.prefix, rest)
+		if len(c) != len(f.prefix) {
+			return nil, notFound("lookup", path, node.ref)
+		}
+		node, rest = f.Node, rest[len(c):]
 	}
-	if len(path) == 0 {
-		return n, nil
-	}
-	f := n.forks[path[0]]
-	if f == nil {
-		return nil, notFound(path)
-	}
-	c := common(f.prefix, path)
-	if len(c) == len(f.prefix) {
-		return f.Node.LookupNode(ctx, path[len(c):], l)
-	}
-	return nil, notFound(path)
 }
 
 // Lookup finds the entry for a path or returns error if not found
@@ -184,61 +191,73 @@ func (n *Node) Lookup(ctx context.Context, path []byte, l Loader) ([]byte, error
 	return node.entry, nil
 }
 
-// Add adds an entry to the path
+// Add adds an entry to the path. Adding an entry and metadata equal to the
+// ones already at the path leaves the node unchanged, as does adding the
+// same entry without metadata.
 func (n *Node) Add(ctx context.Context, path []byte, entry []byte, metadata map[string]string, ls LoadSaver) error {
+	if err := checkMetadata(metadata); err != nil {
+		return err
+	}
+	changed, err := n.add(ctx, path, entry, metadata, ls)
+	if err != nil {
+		return err
+	}
+	if changed {
+		n.touched = append(n.touched, copyBytes(path))
+	}
+	return nil
+}
+
+// add adds the entry and reports whether the trie changed. Nodes are only
+// marked as modified on the way back from a change.
+func (n *Node) add(ctx context.Context, path []byte, entry []byte, metadata map[string]string, ls LoadSaver) (bool, error) {
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return false, ctx.Err()
 	default:
 	}
-	if n.refBytesSize == 0 {
-		if len(entry) > 256 {
-			return fmt.Errorf("node entry size > 256: %d", len(entry))
-		}
-		// empty entry for directories
-		if len(entry) > 0 {
-			n.refBytesSize = len(entry)
-		}
-	} else {
-		if len(entry) > 0 && n.refBytesSize != len(entry) {
-			return fmt.Errorf("invalid entry size: %d, expected: %d", len(entry), n.refBytesSize)
+	if n.forks == nil {
+		if err := n.load(ctx, ls); err != nil {
+			return false, &LoadError{Op: "add", Ref: n.ref, Err: err}
 		}
 	}
+	if err := n.checkEntrySize(entry); err != nil {
+		return false, err
+	}
 
 	if len(path) == 0 {
+		if n.IsValueType() {
+			if err := n.resolveMetadata(ctx, ls); err != nil {
+				return false, &LoadError{Op: "add", Ref: n.ref, Err: err}
+			}
+			if entryEqual(n.entry, entry) && (len(metadata) == 0 || metadataEqual(n.metadata, metadata)) {
+				return false, nil
+			}
+		}
 		n.entry = entry
+		n.makeValue()
 		if len(metadata) > 0 {
 			n.metadata = metadata
 			n.makeWithMetadata()
 		}
 		n.ref = nil
-		return nil
-	}
-	if n.forks == nil {
-		if err := n.load(ctx, ls); err != nil {
-			return err
-		}
-		n.ref = nil
+		return true, nil
 	}
 	f := n.forks[path[0]]
 	if f == nil {
-		nn := New()
-		if len(n.obfuscationKey) > 0 {
-			nn.SetObfuscationKey(n.obfuscationKey)
-		}
-		nn.refBytesSize = n.refBytesSize
+		n.ref = nil
+		nn := n.newChild()
 		// check for prefix size limit
 		if len(path) > nodePrefixMaxSize {
 			prefix := path[:nodePrefixMaxSize]
 			rest := path[nodePrefixMaxSize:]
-			err := nn.Add(ctx, rest, entry, metadata, ls)
-			if err != nil {
-				return err
+			if _, err := nn.add(ctx, rest, entry, metadata, ls); err != nil {
+				return false, prependPath(prefix, err)
 			}
 			nn.updateIsWithPathSeparator(prefix)
 			n.forks[path[0]] = &fork{prefix, nn}
 			n.makeEdge()
-			return nil
+			return true, nil
 		}
 		nn.entry = entry
 		if len(metadata) > 0 {
@@ -249,35 +268,72 @@ func (n *Node) Add(ctx context.Context, path []byte, entry []byte, metadata map[
 		nn.updateIsWithPathSeparator(path)
 		n.forks[path[0]] = &fork{path, nn}
 		n.makeEdge()
-		return nil
+		return true, nil
 	}
 	c := common(f.prefix, path)
 	rest := f.prefix[len(c):]
-	nn := f.Node
-	if len(rest) > 0 {
-		// move current common prefix node
-		nn = New()
-		if len(n.obfuscationKey) > 0 {
-			nn.SetObfuscationKey(n.obfuscationKey)
+	if len(rest) == 0 {
+		// the path continues below the fork
+		changed, err := f.Node.add(ctx, path[len(c):], entry, metadata, ls)
+		if err != nil {
+			return false, prependPath(c, err)
 		}
-		nn.refBytesSize = n.refBytesSize
-		f.Node.updateIsWithPathSeparator(rest)
-		nn.forks[rest[0]] = &fork{rest, f.Node}
-		nn.makeEdge()
-		// if common path is full path new node is value type
-		if len(path) == len(c) {
-			nn.makeValue()
+		if !changed {
+			return false, nil
 		}
+		// NOTE: special case on edge split
+		f.Node.updateIsWithPathSeparator(path)
+		n.ref = nil
+		n.makeEdge()
+		return true, nil
+	}
+	n.ref = nil
+	// move current common prefix node
+	nn := n.newChild()
+	f.Node.updateIsWithPathSeparator(rest)
+	nn.forks[rest[0]] = &fork{rest, f.Node}
+	nn.makeEdge()
+	// if common path is full path new node is value type
+	if len(path) == len(c) {
+		nn.makeValue()
 	}
 	// NOTE: special case on edge split
 	nn.updateIsWithPathSeparator(path)
 	// add new for shared prefix
-	err := nn.Add(ctx, path[len(c):], entry, metadata, ls)
-	if err != nil {
-		return err
+	if _, err := nn.add(ctx, path[len(c):], entry, metadata, ls); err != nil {
+		return false, prependPath(c, err)
 	}
 	n.forks[path[0]] = &fork{c, nn}
 	n.makeEdge()
+	return true, nil
+}
+
+// newChild returns an empty node inheriting the settings of n.
+func (n *Node) newChild() *Node {
+	nn := New()
+	if len(n.obfuscationKey) > 0 {
+		nn.SetObfuscationKey(n.obfuscationKey)
+	}
+	nn.refBytesSize = n.refBytesSize
+	return nn
+}
+
+// checkEntrySize validates the entry size against the one used by the
+// node, adopting it if not yet set.
+func (n *Node) checkEntrySize(entry []byte) error {
+	if n.refBytesSize == 0 {
+		if len(entry) > 256 {
+			return fmt.Errorf("node entry size > 256: %d", len(entry))
+		}
+		// empty entry for directories
+		if len(entry) > 0 {
+			n.refBytesSize = len(entry)
+		}
+	} else {
+		if len(entry) > 0 && n.refBytesSize != len(entry) {
+			return fmt.Errorf("invalid entry size: %d, expected: %d", len(entry), n.refBytesSize)
+		}
+	}
 	return nil
 }
 
@@ -291,6 +347,14 @@ func (n *Node) updateIsWithPathSeparator(path []byte) {
 
 // Remove removes a path from the node
 func (n *Node) Remove(ctx context.Context, path []byte, ls LoadSaver) error {
+	if err := n.remove(ctx, path, ls); err != nil {
+		return err
+	}
+	n.touched = append(n.touched, copyBytes(path))
+	return nil
+}
+
+func (n *Node) remove(ctx context.Context, path []byte, ls LoadSaver) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -301,24 +365,30 @@ func (n *Node) Remove(ctx context.Context, path []byte, ls LoadSaver) error {
 	}
 	if n.forks == nil {
 		if err := n.load(ctx, ls); err != nil {
-			return err
+			return &LoadError{Op: "remove", Ref: n.ref, Err: err}
 		}
 	}
 	f := n.forks[path[0]]
 	if f == nil {
-		return ErrNotFound
+		return notFound("remove", path, n.ref)
 	}
 	prefixIndex := bytes.Index(path, f.prefix)
 	if prefixIndex != 0 {
-		return ErrNotFound
+		return notFound("remove", path, n.ref)
 	}
 	rest := path[len(f.prefix):]
 	if len(rest) == 0 {
 		// full path matched
 		delete(n.forks, path[0])
+		n.ref = nil
 		return nil
 	}
-	return f.Node.Remove(ctx, rest, ls)
+	err := f.Node.remove(ctx, rest, ls)
+	if err != nil {
+		return prependPath(f.prefix, err)
+	}
+	n.ref = nil
+	return nil
 }
 
 func common(a, b []byte) (c []byte) {
@@ -337,7 +407,7 @@ func (n *Node) HasPrefix(ctx context.Context, path []byte, l Loader) (bool, erro
 	}
 	if n.forks == nil {
 		if err := n.load(ctx, l); err != nil {
-			return false, err
+			return false, &LoadError{Op: "lookup", Ref: n.ref, Err: err}
 		}
 	}
 	if len(path) == 0 {
@@ -349,7 +419,11 @@ func (n *Node) HasPrefix(ctx context.Context, path []byte, l Loader) (bool, erro
 	}
 	c := common(f.prefix, path)
 	if len(c) == len(f.prefix) {
-		return f.Node.HasPrefix(ctx, path[len(c):], l)
+		ok, err := f.Node.HasPrefix(ctx, path[len(c):], l)
+		if err != nil {
+			return false, prependPath(c, err)
+		}
+		return ok, nil
 	}
 	if bytes.HasPrefix(f.prefix, path) {
 		return true, nil
@@ -0,0 +1,563 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Patch format constants.
+const (
+	patchMagicString = "mantaray:patch"
+
+	// PatchVersion is the version of the patch encoding produced by this
+	// package.
+	PatchVersion = 1
+)
+
+var (
+	// ErrPreconditionFailed is returned when a patch operation finds an
+	// entry different from the one it expects.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrInvalidPatch is returned when a serialised patch cannot be decoded.
+	ErrInvalidPatch = errors.New("invalid patch")
+)
+
+// OpType is the kind of change made by a patch operation.
+type OpType uint8
+
+const (
+	// OpAdd sets the entry and metadata on a path.
+	OpAdd OpType = iota + 1
+	// OpRemove removes the entry on a path.
+	OpRemove
+	// OpSetMetadata replaces the metadata of an existing entry.
+	OpSetMetadata
+)
+
+var opTypeNames = map[OpType]string{
+	OpAdd:         "add",
+	OpRemove:      "remove",
+	OpSetMetadata: "set-metadata",
+}
+
+func (t OpType) String() string {
+	if s, ok := opTypeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("OpType(%d)", uint8(t))
+}
+
+// Precondition is the expected state of a path before an operation is
+// applied.
+type Precondition struct {
+	// Missing requires that no entry exists on the path.
+	Missing bool
+	// Entry is the expected previous entry when Missing is false.
+	Entry []byte
+}
+
+// Op is a single change to a manifest.
+type Op struct {
+	Type     OpType
+	Path     []byte
+	Entry    []byte
+	Metadata map[string]string
+	// Expected is an optional precondition checked before the change.
+	Expected *Precondition
+}
+
+// Patch is an ordered list of operations that transform one manifest into
+// another. A patch is applied with Node.Apply.
+type Patch struct {
+	Ops []Op
+}
+
+// NewPatch returns the patch that transforms the manifest rooted at a into
+// the one rooted at b. Every operation expects the entry found in a.
+func NewPatch(ctx context.Context, a, b *Node, l Loader) (*Patch, error) {
+	p := &Patch{}
+	err := Diff(ctx, a, b, l, func(path []byte, t DiffType, old, new *Node) error {
+		switch t {
+		case DiffAdded:
+			p.Ops = append(p.Ops, Op{
+				Type:     OpAdd,
+				Path:     path,
+				Entry:    copyBytes(new.entry),
+				Metadata: copyMetadata(new.metadata),
+				Expected: &Precondition{Missing: true},
+			})
+		case DiffRemoved:
+			p.Ops = append(p.Ops, Op{
+				Type:     OpRemove,
+				Path:     path,
+				Expected: &Precondition{Entry: copyBytes(old.entry)},
+			})
+		case DiffModified:
+			op := Op{
+				Type:     OpAdd,
+				Path:     path,
+				Entry:    copyBytes(new.entry),
+				Metadata: copyMetadata(new.metadata),
+				Expected: &Precondition{Entry: copyBytes(old.entry)},
+			}
+			if entryEqual(old.entry, new.entry) {
+				op.Type = OpSetMetadata
+				op.Entry = nil
+			}
+			p.Ops = append(p.Ops, op)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Apply applies all operations of the patch in order. If any operation
+// fails, including a failed precondition, the node is left unchanged and
+// the error is an *OpError.
+func (n *Node) Apply(ctx context.Context, p *Patch, ls LoadSaver) error {
+	c := n.clone()
+	for i := range p.Ops {
+		op := &p.Ops[i]
+		if err := c.applyOp(ctx, op.Path, op, ls); err != nil {
+			return &OpError{Index: i, Op: op, Err: err}
+		}
+	}
+	*n = *c
+	return nil
+}
+
+// applyOp applies the operation to the path relative to n.
+func (n *Node) applyOp(ctx context.Context, path []byte, op *Op, ls LoadSaver) error {
+	if err := checkMetadata(op.Metadata); err != nil {
+		return err
+	}
+	if op.Expected != nil {
+		if err := n.checkPrecondition(ctx, path, op.Expected, ls); err != nil {
+			return err
+		}
+	}
+	switch op.Type {
+	case OpAdd:
+		if err := n.Add(ctx, path, op.Entry, op.Metadata, ls); err != nil {
+			return err
+		}
+		if len(op.Metadata) > 0 {
+			return nil
+		}
+		// Add keeps the previous metadata when none is given
+		return n.updatePath(ctx, path, ls, func(nn *Node) (bool, error) {
+			if len(nn.metadata) == 0 {
+				return false, nil
+			}
+			nn.setMetadata(nil)
+			return true, nil
+		})
+	case OpRemove:
+		return n.removeEntry(ctx, path, ls)
+	case OpSetMetadata:
+		return n.updatePath(ctx, path, ls, func(nn *Node) (bool, error) {
+			if !nn.IsValueType() {
+				return false, notFound("apply", path, nn.ref)
+			}
+			if metadataEqual(nn.metadata, op.Metadata) {
+				return false, nil
+			}
+			nn.setMetadata(op.Metadata)
+			return true, nil
+		})
+	}
+	return fmt.Errorf("unknown operation type %d: %w", op.Type, ErrInvalid)
+}
+
+func (n *Node) checkPrecondition(ctx context.Context, path []byte, p *Precondition, l Loader) error {
+	nn, err := n.LookupNode(ctx, path, l)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err != nil {
+		nn = nil
+	}
+	return checkExpected(nn, p)
+}
+
+// checkExpected checks the precondition against the node on its path, nil
+// if there is none.
+func checkExpected(nn *Node, p *Precondition) error {
+	exists := nn != nil && nn.IsValueType()
+	switch {
+	case p.Missing && exists:
+		return fmt.Errorf("entry exists: %w", ErrPreconditionFailed)
+	case !p.Missing && !exists:
+		return fmt.Errorf("entry missing: %w", ErrPreconditionFailed)
+	case !p.Missing && !entryEqual(nn.entry, p.Entry):
+		return fmt.Errorf("entry %x, expected %x: %w", nn.entry, p.Entry, ErrPreconditionFailed)
+	}
+	return nil
+}
+
+// removeEntry removes the entry on path. Unlike Remove, it keeps the
+// entries nested under the path.
+func (n *Node) removeEntry(ctx context.Context, path []byte, ls LoadSaver) error {
+	nn, err := n.LookupNode(ctx, path, ls)
+	if err != nil {
+		return err
+	}
+	if !nn.IsValueType() {
+		return notFound("remove", path, nn.ref)
+	}
+	if len(nn.forks) == 0 {
+		return n.Remove(ctx, path, ls)
+	}
+	return n.updatePath(ctx, path, ls, func(nn *Node) (bool, error) {
+		// the entry is serialised with the node, loaded by the lookup above
+		nn.ref = nil
+		nn.makeNotValue()
+		nn.entry = nil
+		nn.setMetadata(nil)
+		return true, nil
+	})
+}
+
+// updatePath is update recording path as changed on n if fn changed the
+// node on it.
+func (n *Node) updatePath(ctx context.Context, path []byte, ls LoadSaver, fn func(*Node) (bool, error)) error {
+	changed, err := n.update(ctx, path, ls, fn)
+	if err != nil {
+		return err
+	}
+	if changed {
+		n.touched = append(n.touched, copyBytes(path))
+	}
+	return nil
+}
+
+// update calls fn with the node on path and, if fn reports a change, marks
+// every node above it as modified.
+func (n *Node) update(ctx context.Context, path []byte, ls LoadSaver, fn func(*Node) (bool, error)) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	default:
+	}
+	if len(path) == 0 {
+		if err := n.resolveMetadata(ctx, ls); err != nil {
+			return false, &LoadError{Op: "apply", Ref: n.ref, Err: err}
+		}
+		return fn(n)
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, ls); err != nil {
+			return false, &LoadError{Op: "apply", Ref: n.ref, Err: err}
+		}
+	}
+	f := n.forks[path[0]]
+	if f == nil || !bytes.HasPrefix(path, f.prefix) {
+		return false, notFound("apply", path, n.ref)
+	}
+	changed, err := f.Node.update(ctx, path[len(f.prefix):], ls, fn)
+	if err != nil {
+		return false, prependPath(f.prefix, err)
+	}
+	if changed {
+		n.ref = nil
+	}
+	return changed, nil
+}
+
+func (n *Node) setMetadata(metadata map[string]string) {
+	if len(metadata) == 0 {
+		n.metadata = nil
+		n.makeNotWithMetadata()
+		return
+	}
+	n.metadata = metadata
+	n.makeWithMetadata()
+}
+
+// clone returns a copy of the in-memory part of the trie rooted at n.
+// Nodes that are not loaded are copied too, so loading them in the clone
+// leaves the original untouched.
+func (n *Node) clone() *Node {
+	c := *n
+	c.touched = append([][]byte(nil), n.touched...)
+	if n.forks != nil {
+		c.forks = make(map[byte]*fork, len(n.forks))
+		for k, f := range n.forks {
+			c.forks[k] = &fork{prefix: f.prefix, Node: f.Node.clone()}
+		}
+	}
+	return &c
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func copyMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+const (
+	opFlagExpected = 1 << iota
+	opFlagMissing
+)
+
+// MarshalBinary serialises the patch
+func (p *Patch) MarshalBinary() ([]byte, error) {
+	b := append([]byte{}, patchMagicString...)
+	b = append(b, PatchVersion)
+	b = appendUvarint(b, uint64(len(p.Ops)))
+	for _, op := range p.Ops {
+		if _, ok := opTypeNames[op.Type]; !ok {
+			return nil, fmt.Errorf("unknown operation type %d: %w", op.Type, ErrInvalid)
+		}
+		var flags byte
+		if op.Expected != nil {
+			flags |= opFlagExpected
+			if op.Expected.Missing {
+				flags |= opFlagMissing
+			}
+		}
+		b = append(b, byte(op.Type), flags)
+		b = appendBytes(b, op.Path)
+		b = appendBytes(b, op.Entry)
+		keys := make([]string, 0, len(op.Metadata))
+		for k := range op.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = appendUvarint(b, uint64(len(keys)))
+		for _, k := range keys {
+			b = appendBytes(b, []byte(k))
+			b = appendBytes(b, []byte(op.Metadata[k]))
+		}
+		if op.Expected != nil && !op.Expected.Missing {
+			b = appendBytes(b, op.Expected.Entry)
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary deserialises a patch
+func (p *Patch) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(patchMagicString)) {
+		return fmt.Errorf("missing header: %w", ErrInvalidPatch)
+	}
+	r := &patchReader{data: data[len(patchMagicString):]}
+	if v := r.byte(); v != PatchVersion {
+		return fmt.Errorf("unsupported version %d: %w", v, ErrInvalidPatch)
+	}
+	count := r.uvarint()
+	var ops []Op
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		op := Op{
+			Type: OpType(r.byte()),
+		}
+		flags := r.byte()
+		op.Path = r.bytes()
+		op.Entry = r.bytes()
+		if m := r.uvarint(); m > 0 && r.err == nil {
+			op.Metadata = make(map[string]string)
+			for j := uint64(0); j < m && r.err == nil; j++ {
+				k := r.bytes()
+				op.Metadata[string(k)] = string(r.bytes())
+			}
+		}
+		if flags&opFlagExpected != 0 {
+			op.Expected = &Precondition{Missing: flags&opFlagMissing != 0}
+			if !op.Expected.Missing {
+				op.Expected.Entry = r.bytes()
+			}
+		}
+		if _, ok := opTypeNames[op.Type]; !ok && r.err == nil {
+			r.err = fmt.Errorf("unknown operation type %d", op.Type)
+		}
+		ops = append(ops, op)
+	}
+	if r.err == nil && len(r.data) > 0 {
+		r.err = fmt.Errorf("%d trailing bytes", len(r.data))
+	}
+	if r.err != nil {
+		return fmt.Errorf("%v: %w", r.err, ErrInvalidPatch)
+	}
+	p.Ops = ops
+	return nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendBytes(b, v []byte) []byte {
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// patchReader consumes a serialised patch, recording the first error.
+type patchReader struct {
+	data []byte
+	err  error
+}
+
+func (r *patchReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) == 0 {
+		r.err = ErrTooShort
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *patchReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = ErrTooShort
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *patchReader) bytes() []byte {
+	l := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.data)) < l {
+		r.err = ErrTooShort
+		return nil
+	}
+	if l == 0 {
+		return nil
+	}
+	b := append([]byte{}, r.data[:l]...)
+	r.data = r.data[l:]
+	return b
+}
+
+type patchJSON struct {
+	Version int      `json:"version"`
+	Ops     []opJSON `json:"ops"`
+}
+
+type opJSON struct {
+	Op       string            `json:"op"`
+	Path     string            `json:"path"`
+	Entry    string            `json:"entry,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Expected *preconditionJSON `json:"expected,omitempty"`
+}
+
+type preconditionJSON struct {
+	Missing bool   `json:"missing,omitempty"`
+	Entry   string `json:"entry,omitempty"`
+}
+
+// MarshalJSON encodes the patch as JSON with hex encoded entries.
+func (p *Patch) MarshalJSON() ([]byte, error) {
+	pj := patchJSON{
+		Version: PatchVersion,
+		Ops:     make([]opJSON, 0, len(p.Ops)),
+	}
+	for _, op := range p.Ops {
+		name, ok := opTypeNames[op.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown operation type %d: %w", op.Type, ErrInvalid)
+		}
+		if !utf8.Valid(op.Path) {
+			return nil, fmt.Errorf("path %x is not valid UTF-8: %w", op.Path, ErrInvalid)
+		}
+		oj := opJSON{
+			Op:       name,
+			Path:     string(op.Path),
+			Entry:    hex.EncodeToString(op.Entry),
+			Metadata: op.Metadata,
+		}
+		if op.Expected != nil {
+			oj.Expected = &preconditionJSON{
+				Missing: op.Expected.Missing,
+				Entry:   hex.EncodeToString(op.Expected.Entry),
+			}
+		}
+		pj.Ops = append(pj.Ops, oj)
+	}
+	return json.Marshal(pj)
+}
+
+// UnmarshalJSON decodes a patch encoded by MarshalJSON.
+func (p *Patch) UnmarshalJSON(data []byte) error {
+	var pj patchJSON
+	if err := json.Unmarshal(data, &pj); err != nil {
+		return err
+	}
+	if pj.Version != PatchVersion {
+		return fmt.Errorf("unsupported version %d: %w", pj.Version, ErrInvalidPatch)
+	}
+	ops := make([]Op, 0, len(pj.Ops))
+	for i, oj := range pj.Ops {
+		op := Op{
+			Path:     []byte(oj.Path),
+			Metadata: oj.Metadata,
+		}
+		for t, name := range opTypeNames {
+			if name == oj.Op {
+				op.Type = t
+			}
+		}
+		if op.Type == 0 {
+			return fmt.Errorf("operation %d: unknown type %q: %w", i, oj.Op, ErrInvalidPatch)
+		}
+		var err error
+		if op.Entry, err = decodeHex(oj.Entry); err != nil {
+			return fmt.Errorf("operation %d: entry: %v: %w", i, err, ErrInvalidPatch)
+		}
+		if oj.Expected != nil {
+			op.Expected = &Precondition{Missing: oj.Expected.Missing}
+			if op.Expected.Entry, err = decodeHex(oj.Expected.Entry); err != nil {
+				return fmt.Errorf("operation %d: expected entry: %v: %w", i, err, ErrInvalidPatch)
+			}
+		}
+		ops = append(ops, op)
+	}
+	p.Ops = ops
+	return nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(s)
+}
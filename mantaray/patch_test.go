@@ -0,0 +1,176 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func savedManifest(t *testing.T, ls mantaray.LoadSaver, entries map[string]map[string]string) []byte {
+	t.Helper()
+	ctx := context.Background()
+	n := mantaray.New()
+	for p, m := range entries {
+		if err := n.Add(ctx, []byte(p), testEntry(p), m, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return n.Reference()
+}
+
+func TestPatchApply(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	old := savedManifest(t, ls, map[string]map[string]string{
+		"index.html": {"Content-Type": "text/html"},
+		"img/1.png":  nil,
+		"img/2.png":  nil,
+	})
+	new := savedManifest(t, ls, map[string]map[string]string{
+		"index.html": {"Content-Type": "text/html; charset=utf-8"},
+		"img/2.png":  nil,
+		"robots.txt": nil,
+	})
+
+	p, err := mantaray.NewPatch(ctx, mantaray.NewNodeRef(old), mantaray.NewNodeRef(new), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(p.Ops) != 3 {
+		t.Fatalf("expected 3 operations, got %d", len(p.Ops))
+	}
+
+	n := mantaray.NewNodeRef(old)
+	if err := n.Apply(ctx, p, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err = mantaray.Diff(ctx, mantaray.NewNodeRef(n.Reference()), mantaray.NewNodeRef(new), ls, func(path []byte, _ mantaray.DiffType, _, _ *mantaray.Node) error {
+		t.Errorf("unexpected difference on %s", path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// applying the same patch again fails the preconditions
+	ref := n.Reference()
+	err = n.Apply(ctx, p, ls)
+	if !errors.Is(err, mantaray.ErrPreconditionFailed) {
+		t.Fatalf("expected precondition error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(ref, n.Reference()) {
+		t.Fatalf("expected failed patch to leave node unchanged")
+	}
+}
+
+func TestPatchApplyAtomic(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	if err := n.Add(ctx, []byte("a.txt"), testEntry("a.txt"), nil, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	p := &mantaray.Patch{Ops: []mantaray.Op{
+		{Type: mantaray.OpAdd, Path: []byte("b.txt"), Entry: testEntry("b.txt")},
+		{Type: mantaray.OpRemove, Path: []byte("c.txt")},
+	}}
+	if err := n.Apply(ctx, p, nil); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if _, err := n.Lookup(ctx, []byte("b.txt"), nil); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected b.txt not to be added, got %v", err)
+	}
+}
+
+func TestPatchRemoveKeepsNested(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, p := range []string{"a", "a/b"} {
+		if err := n.Add(ctx, []byte(p), testEntry(p), nil, nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	p := &mantaray.Patch{Ops: []mantaray.Op{
+		{Type: mantaray.OpRemove, Path: []byte("a"), Expected: &mantaray.Precondition{Entry: testEntry("a")}},
+	}}
+	if err := n.Apply(ctx, p, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := n.Lookup(ctx, []byte("a/b"), nil); err != nil {
+		t.Fatalf("expected a/b to be kept, got %v", err)
+	}
+}
+
+func TestPatchEncoding(t *testing.T) {
+	p := &mantaray.Patch{Ops: []mantaray.Op{
+		{
+			Type:     mantaray.OpAdd,
+			Path:     []byte("index.html"),
+			Entry:    testEntry("index.html"),
+			Metadata: map[string]string{"Content-Type": "text/html", "Filename": "index.html"},
+			Expected: &mantaray.Precondition{Missing: true},
+		},
+		{
+			Type:     mantaray.OpRemove,
+			Path:     []byte("img/1.png"),
+			Expected: &mantaray.Precondition{Entry: testEntry("img/1.png")},
+		},
+		{
+			Type:     mantaray.OpSetMetadata,
+			Path:     []byte("robots.txt"),
+			Metadata: map[string]string{"Content-Type": "text/plain"},
+		},
+	}}
+
+	t.Run("binary", func(t *testing.T) {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		q := &mantaray.Patch{}
+		if err := q.UnmarshalBinary(b); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(p, q) {
+			t.Fatalf("expected %+v, got %+v", p, q)
+		}
+		for i := 0; i < len(b); i++ {
+			if err := q.UnmarshalBinary(b[:i]); !errors.Is(err, mantaray.ErrInvalidPatch) {
+				t.Fatalf("expected invalid patch error on %d bytes, got %v", i, err)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		q := &mantaray.Patch{}
+		if err := json.Unmarshal(b, q); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(p, q) {
+			t.Fatalf("expected %+v, got %+v", p, q)
+		}
+	})
+}
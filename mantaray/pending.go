@@ -0,0 +1,53 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Pending describes what saving a trie would write.
+type Pending struct {
+	// Nodes is the number of nodes without a reference.
+	Nodes int
+	// Bytes is the estimated serialised size of those nodes.
+	Bytes int
+	// Paths lists the paths added, removed or whose metadata changed since
+	// the last save, through Add, Remove, Apply or ApplyOps, sorted and
+	// without duplicates.
+	Paths [][]byte
+}
+
+// Pending reports the unsaved changes of the trie rooted at n. A node
+// without changes reports none.
+func (n *Node) Pending() *Pending {
+	p := &Pending{}
+	pending(n, nil, p)
+	sort.Slice(p.Paths, func(i, j int) bool { return bytes.Compare(p.Paths[i], p.Paths[j]) < 0 })
+	paths := p.Paths[:0]
+	for i, path := range p.Paths {
+		if i == 0 || !bytes.Equal(path, p.Paths[i-1]) {
+			paths = append(paths, path)
+		}
+	}
+	p.Paths = paths
+	return p
+}
+
+// pending adds the changes of the node n at path to p.
+func pending(n *Node, path []byte, p *Pending) {
+	if n.ref != nil {
+		return
+	}
+	p.Nodes++
+	p.Bytes += n.estimatedSize()
+	for _, t := range n.touched {
+		p.Paths = append(p.Paths, appendPath(path, t))
+	}
+	for _, f := range n.forks {
+		pending(f.Node, appendPath(path, f.prefix), p)
+	}
+}
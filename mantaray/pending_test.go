@@ -0,0 +1,110 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func TestPending(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	ref := savedManifest(t, ls, map[string]map[string]string{
+		"img/1.png":  nil,
+		"img/2.png":  nil,
+		"index.html": nil,
+	})
+
+	n := mantaray.NewNodeRef(ref)
+	if _, err := n.Lookup(ctx, []byte("index.html"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if p := n.Pending(); p.Nodes != 0 || p.Bytes != 0 || len(p.Paths) != 0 {
+		t.Fatalf("expected no pending changes, got %+v", p)
+	}
+
+	if err := n.Add(ctx, []byte("img/3.png"), testEntry("img/3.png"), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Remove(ctx, []byte("img/1.png"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Add(ctx, []byte("img/3.png"), testEntry("img/3.png"), map[string]string{"k": "v"}, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	p := n.Pending()
+	if p.Nodes == 0 || p.Bytes == 0 {
+		t.Fatalf("expected pending nodes, got %+v", p)
+	}
+	want := [][]byte{[]byte("img/1.png"), []byte("img/3.png")}
+	if !reflect.DeepEqual(p.Paths, want) {
+		t.Fatalf("expected paths %q, got %q", want, p.Paths)
+	}
+
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if p := n.Pending(); p.Nodes != 0 || p.Bytes != 0 || len(p.Paths) != 0 {
+		t.Fatalf("expected no pending changes after save, got %+v", p)
+	}
+}
+
+func TestAddUnchanged(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	ref := savedManifest(t, ls, map[string]map[string]string{
+		"a/b.txt": {"Content-Type": "text/plain"},
+		"c.txt":   nil,
+	})
+
+	n := mantaray.NewNodeRef(ref)
+	if err := n.Add(ctx, []byte("a/b.txt"), testEntry("a/b.txt"), map[string]string{"Content-Type": "text/plain"}, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if p := n.Pending(); p.Nodes != 0 {
+		t.Fatalf("expected no pending changes, got %+v", p)
+	}
+	cs := &countingSaver{LoadSaver: ls}
+	if err := n.Save(ctx, cs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cs.saves != 0 {
+		t.Fatalf("expected no saves, got %d", cs.saves)
+	}
+	if !bytes.Equal(n.Reference(), ref) {
+		t.Fatalf("expected reference %x, got %x", ref, n.Reference())
+	}
+}
+
+func TestPendingMetadata(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	ref := savedManifest(t, ls, map[string]map[string]string{
+		"a.txt": {"Content-Type": "text/plain"},
+		"b.txt": {"Content-Type": "text/plain"},
+		"c.txt": nil,
+	})
+
+	n := mantaray.NewNodeRef(ref)
+	err := n.Apply(ctx, &mantaray.Patch{Ops: []mantaray.Op{
+		{Type: mantaray.OpSetMetadata, Path: []byte("a.txt"), Metadata: map[string]string{"Content-Type": "text/html"}},
+		// adding the same entry without metadata clears it
+		{Type: mantaray.OpAdd, Path: []byte("b.txt"), Entry: testEntry("b.txt")},
+		// no change
+		{Type: mantaray.OpAdd, Path: []byte("c.txt"), Entry: testEntry("c.txt")},
+	}}, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := [][]byte{[]byte("a.txt"), []byte("b.txt")}
+	if p := n.Pending(); !reflect.DeepEqual(p.Paths, want) {
+		t.Fatalf("expected paths %q, got %q", want, p.Paths)
+	}
+}
@@ -6,7 +6,12 @@ package mantaray
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"sync"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -16,6 +21,10 @@ var (
 	ErrNoSaver = errors.New("Node is not persisted but no saver")
 	// ErrNoLoader saver interface not given
 	ErrNoLoader = errors.New("Node is reference but no loader")
+	// ErrBatchSize batch call returned a wrong number of results
+	ErrBatchSize = errors.New("batch result size mismatch")
+	// ErrReservedMetadataKey metadata uses the key of metadata saved separately
+	ErrReservedMetadataKey = errors.New("reserved metadata key")
 )
 
 // Loader defines a generic interface to retrieve nodes
@@ -38,6 +47,22 @@ type LoadSaver interface {
 	Saver
 }
 
+// BatchLoader is a Loader that can also retrieve many nodes in one call.
+// Save, Walk and Diff use it to load the forks of a node together.
+type BatchLoader interface {
+	Loader
+	// LoadMany returns the data of the references in the same order.
+	LoadMany(ctx context.Context, references [][]byte) (data [][]byte, err error)
+}
+
+// BatchSaver is a Saver that can also persist many nodes in one call.
+// Save uses it to persist each level of the trie together.
+type BatchSaver interface {
+	Saver
+	// SaveMany returns the references of the data in the same order.
+	SaveMany(ctx context.Context, data [][]byte) (references [][]byte, err error)
+}
+
 func (n *Node) load(ctx context.Context, l Loader) error {
 	if n == nil || n.ref == nil {
 		return nil
@@ -52,15 +77,134 @@ func (n *Node) load(ctx context.Context, l Loader) error {
 	return n.UnmarshalBinary(b)
 }
 
+// loadAll loads the nodes whose forks are not in memory, with a single
+// call if l is a BatchLoader and one by one otherwise.
+func loadAll(ctx context.Context, l Loader, nodes []*Node) error {
+	var unloaded []*Node
+	var refs [][]byte
+	for _, n := range nodes {
+		if n.forks == nil && n.ref != nil {
+			unloaded = append(unloaded, n)
+			refs = append(refs, n.ref)
+		}
+	}
+	if len(unloaded) == 0 {
+		return nil
+	}
+	bl, ok := l.(BatchLoader)
+	if !ok {
+		for _, n := range unloaded {
+			if err := n.load(ctx, l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	data, err := bl.LoadMany(ctx, refs)
+	if err != nil {
+		return err
+	}
+	if len(data) != len(refs) {
+		return fmt.Errorf("loaded %d of %d references: %w", len(data), len(refs), ErrBatchSize)
+	}
+	for i, n := range unloaded {
+		if err := n.UnmarshalBinary(data[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forkNodes returns the fork nodes of n in fork order.
+func forkNodes(n *Node) []*Node {
+	nodes := make([]*Node, 0, len(n.forks))
+	for _, k := range sortedKeys(n.forks) {
+		nodes = append(nodes, n.forks[k].Node)
+	}
+	return nodes
+}
+
 // Save persists a trie recursively  traversing the nodes
 func (n *Node) Save(ctx context.Context, s Saver) error {
+	return n.SaveWithOptions(ctx, s, nil)
+}
+
+// SaveOptions configure SaveWithOptions.
+type SaveOptions struct {
+	// Concurrency limits the number of nodes saved at the same time. A
+	// value of 0 sets no limit.
+	Concurrency int
+	// Progress, if set, is called after each saved node. Calls are never
+	// made concurrently.
+	Progress func(SaveProgress)
+	// MaxNodeSize is the maximum size of a saved node, such as ChunkSize.
+	// A node that would exceed it has the metadata of its forks saved as
+	// separate blobs, largest first, until it fits. Only metadata is moved
+	// out, so a node with too many forks still fails with
+	// ErrNodeTooLarge. A value of 0 sets no limit.
+	MaxNodeSize int
+}
+
+// SaveProgress reports the state of a save.
+type SaveProgress struct {
+	// Nodes is the number of nodes saved so far.
+	Nodes int
+	// Bytes is the size of the nodes saved so far.
+	Bytes int
+	// TotalNodes is the number of nodes that need saving.
+	TotalNodes int
+}
+
+// SaveWithOptions persists a trie like Save. When the save fails or is
+// canceled, the subtrees that were saved keep their references, so saving
+// again only saves the missing nodes. If s is a BatchSaver, each level of
+// the trie is saved with one call, deepest first, and Concurrency is not
+// used.
+func (n *Node) SaveWithOptions(ctx context.Context, s Saver, o *SaveOptions) error {
 	if s == nil {
-		return ErrNoSaver
+		return &SaveError{Op: "save", Err: ErrNoSaver}
+	}
+	if o == nil {
+		o = &SaveOptions{}
+	}
+	sv := &nodeSaver{s: s, maxSize: o.MaxNodeSize, progress: o.Progress}
+	if o.Concurrency > 0 {
+		// the calling goroutine saves nodes too
+		sv.tokens = make(chan struct{}, o.Concurrency-1)
+	}
+	if sv.progress != nil {
+		sv.state.TotalNodes = unsaved(n)
+	}
+	if bs, ok := s.(BatchSaver); ok {
+		return sv.saveLevels(ctx, n, bs)
+	}
+	return sv.save(ctx, n)
+}
+
+// unsaved counts the nodes without a reference.
+func unsaved(n *Node) int {
+	if n.ref != nil {
+		return 0
+	}
+	count := 1
+	for _, f := range n.forks {
+		count += unsaved(f.Node)
 	}
-	return n.save(ctx, s)
+	return count
 }
 
-func (n *Node) save(ctx context.Context, s Saver) error {
+// nodeSaver saves nodes with a bounded number of goroutines.
+type nodeSaver struct {
+	s        Saver
+	maxSize  int
+	tokens   chan struct{} // nil for no limit
+	progress func(SaveProgress)
+
+	mu    sync.Mutex
+	state SaveProgress
+}
+
+func (sv *nodeSaver) save(ctx context.Context, n *Node) error {
 	if n != nil && n.ref != nil {
 		return nil
 	}
@@ -70,23 +214,232 @@ func (n *Node) save(ctx context.Context, s Saver) error {
 	default:
 	}
 	eg, ectx := errgroup.WithContext(ctx)
-	for _, f := range n.forks {
-		f := f
-		eg.Go(func() error {
-			return f.Node.save(ectx, s)
-		})
+	for _, k := range sortedKeys(n.forks) {
+		f := n.forks[k]
+		if f.Node.ref != nil {
+			continue
+		}
+		if sv.acquire() {
+			eg.Go(func() error {
+				defer sv.release()
+				return prependPath(f.prefix, sv.save(ectx, f.Node))
+			})
+			continue
+		}
+		// no goroutine available, save in this one
+		if err := sv.save(ectx, f.Node); err != nil {
+			_ = eg.Wait()
+			return prependPath(f.prefix, err)
+		}
 	}
 	if err := eg.Wait(); err != nil {
 		return err
 	}
-	bytes, err := n.MarshalBinary()
+	bytes, err := n.marshal(ctx, sv.s, sv.maxSize)
 	if err != nil {
-		return err
+		return &SaveError{Op: "save", Err: err}
 	}
-	n.ref, err = s.Save(ctx, bytes)
+	ref, err := sv.s.Save(ctx, bytes)
 	if err != nil {
-		return err
+		return &SaveError{Op: "save", Ref: ref, Err: err}
 	}
+	n.ref = ref
 	n.forks = nil
+	n.touched = nil
+	sv.report(len(bytes))
 	return nil
 }
+
+// saveLevels saves the nodes without a reference with one call per level
+// of the trie, so that children are saved before their parents.
+func (sv *nodeSaver) saveLevels(ctx context.Context, n *Node, bs BatchSaver) error {
+	levels := unsavedLevels(n, nil, 0, nil)
+	for i := len(levels) - 1; i >= 0; i-- {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		nodes := levels[i]
+		data := make([][]byte, len(nodes))
+		for j, c := range nodes {
+			b, err := c.node.marshal(ctx, sv.s, sv.maxSize)
+			if err != nil {
+				return &SaveError{Op: "save", Path: c.path, Err: err}
+			}
+			data[j] = b
+		}
+		refs, err := bs.SaveMany(ctx, data)
+		if err != nil {
+			return &SaveError{Op: "save", Path: nodes[0].path, Err: err}
+		}
+		if len(refs) != len(data) {
+			err := fmt.Errorf("saved %d of %d nodes: %w", len(refs), len(data), ErrBatchSize)
+			return &SaveError{Op: "save", Path: nodes[0].path, Err: err}
+		}
+		for j, pn := range nodes {
+			c := pn.node
+			c.ref = refs[j]
+			c.forks = nil
+			c.touched = nil
+			sv.report(len(data[j]))
+		}
+	}
+	return nil
+}
+
+// unsavedLevels appends the nodes without a reference of the trie rooted
+// at n on path to levels by depth.
+func unsavedLevels(n *Node, path []byte, depth int, levels [][]pathNode) [][]pathNode {
+	if n.ref != nil {
+		return levels
+	}
+	if len(levels) == depth {
+		levels = append(levels, nil)
+	}
+	levels[depth] = append(levels[depth], pathNode{path, n})
+	for _, k := range sortedKeys(n.forks) {
+		f := n.forks[k]
+		levels = unsavedLevels(f.Node, appendPath(path, f.prefix), depth+1, levels)
+	}
+	return levels
+}
+
+func (sv *nodeSaver) acquire() bool {
+	if sv.tokens == nil {
+		return true
+	}
+	select {
+	case sv.tokens <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (sv *nodeSaver) release() {
+	if sv.tokens != nil {
+		<-sv.tokens
+	}
+}
+
+func (sv *nodeSaver) report(size int) {
+	if sv.progress == nil {
+		return
+	}
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.state.Nodes++
+	sv.state.Bytes += size
+	sv.progress(sv.state)
+}
+
+// metadataRefKey is the only metadata key of a fork whose metadata is
+// saved in a separate blob. Its value is the hex encoded reference of the
+// blob, which holds the metadata encoded as JSON. The node stays readable
+// by other implementations, which see the key as ordinary metadata. The
+// key is reserved, so metadata holding it is rejected when added.
+const metadataRefKey = "mantaray:metadata-ref"
+
+// checkMetadata rejects metadata using the reserved metadataRefKey.
+func checkMetadata(metadata map[string]string) error {
+	if _, ok := metadata[metadataRefKey]; ok {
+		return fmt.Errorf("metadata key %q: %w", metadataRefKey, ErrReservedMetadataKey)
+	}
+	return nil
+}
+
+// marshal serialises n. If the node exceeds maxSize, the largest fork
+// metadata is saved as separate blobs until it fits.
+func (n *Node) marshal(ctx context.Context, s Saver, maxSize int) ([]byte, error) {
+	b, err := n.marshalBinary(maxSize)
+	if !errors.Is(err, ErrNodeTooLarge) {
+		return b, err
+	}
+
+	type spill struct {
+		k        byte
+		metadata []byte
+	}
+	var spills []spill
+	for k, f := range n.forks {
+		if !f.Node.IsWithMetadataType() || isMetadataRef(f.Node.metadata) {
+			continue
+		}
+		m, err := json.Marshal(f.Node.metadata)
+		if err != nil {
+			return nil, err
+		}
+		spills = append(spills, spill{k, m})
+	}
+	sort.Slice(spills, func(i, j int) bool {
+		if len(spills[i].metadata) != len(spills[j].metadata) {
+			return len(spills[i].metadata) > len(spills[j].metadata)
+		}
+		return spills[i].k < spills[j].k
+	})
+
+	// the forks of a copy are replaced, so n and its children are never
+	// modified; n already holds the obfuscation key shared by the copy
+	c := *n
+	c.forks = make(map[byte]*fork, len(n.forks))
+	for k, f := range n.forks {
+		c.forks[k] = f
+	}
+	for _, sp := range spills {
+		ref, err := s.Save(ctx, sp.metadata)
+		if err != nil {
+			return nil, err
+		}
+		f := c.forks[sp.k]
+		child := *f.Node
+		child.metadata = map[string]string{metadataRefKey: hex.EncodeToString(ref)}
+		c.forks[sp.k] = &fork{prefix: f.prefix, Node: &child}
+
+		if b, err := c.marshalBinary(maxSize); !errors.Is(err, ErrNodeTooLarge) {
+			return b, err
+		}
+	}
+	return nil, err
+}
+
+// loadMetadata returns metadata, or its content loaded through l if it
+// was saved as a separate blob.
+func loadMetadata(ctx context.Context, l Loader, metadata map[string]string) (map[string]string, error) {
+	if !isMetadataRef(metadata) {
+		return metadata, nil
+	}
+	if l == nil {
+		return nil, ErrNoLoader
+	}
+	ref, err := hex.DecodeString(metadata[metadataRefKey])
+	if err != nil {
+		return nil, fmt.Errorf("metadata reference: %w", err)
+	}
+	b, err := l.Load(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]string)
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// resolveMetadata replaces metadata of n saved as a separate blob with its
+// content. Loading a node leaves the metadata of its forks as references,
+// so only the metadata of nodes returned to callers is loaded.
+func (n *Node) resolveMetadata(ctx context.Context, l Loader) error {
+	metadata, err := loadMetadata(ctx, l, n.metadata)
+	if err != nil {
+		return err
+	}
+	n.metadata = metadata
+	return nil
+}
+
+func isMetadataRef(metadata map[string]string) bool {
+	_, ok := metadata[metadataRefKey]
+	return ok && len(metadata) == 1
+}
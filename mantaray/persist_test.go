@@ -8,8 +8,13 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ethersphere/manifest/mantaray"
 )
@@ -60,6 +65,35 @@ func TestPersistIdempotence(t *testing.T) {
 	}
 }
 
+func TestPersistWalk(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := mantaray.New()
+	paths := []string{"index.html", "img/1.png", "img/2.png"}
+	for _, p := range paths {
+		if err := n.Add(ctx, []byte(p), testEntry(p), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	files := 0
+	err := mantaray.NewNodeRef(n.Reference()).Walk(ctx, []byte{}, ls, func(_ []byte, isDir bool, err error) error {
+		if !isDir {
+			files++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if files != len(paths) {
+		t.Fatalf("expected %d files, got %d", len(paths), files)
+	}
+}
+
 type addr [32]byte
 type mockLoadSaver struct {
 	mtx   sync.Mutex
@@ -97,3 +131,396 @@ func (m *mockLoadSaver) Load(_ context.Context, ab []byte) ([]byte, error) {
 	}
 	return b, nil
 }
+
+type sizeCheckingSaver struct {
+	mantaray.LoadSaver
+	max int
+}
+
+func (s *sizeCheckingSaver) Save(ctx context.Context, b []byte) ([]byte, error) {
+	if len(b) > s.max {
+		return nil, fmt.Errorf("saved %d bytes", len(b))
+	}
+	return s.LoadSaver.Save(ctx, b)
+}
+
+// savedManifestMax is like savedManifest, saving nodes of at most
+// maxNodeSize bytes.
+func savedManifestMax(t *testing.T, ls mantaray.LoadSaver, entries map[string]map[string]string, maxNodeSize int) []byte {
+	t.Helper()
+	ctx := context.Background()
+	n := mantaray.New()
+	for p, m := range entries {
+		if err := n.Add(ctx, []byte(p), testEntry(p), m, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.SaveWithOptions(ctx, ls, &mantaray.SaveOptions{MaxNodeSize: maxNodeSize}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return n.Reference()
+}
+
+func TestPersistMaxNodeSize(t *testing.T) {
+	ctx := context.Background()
+	o := &mantaray.SaveOptions{MaxNodeSize: mantaray.ChunkSize}
+
+	metadata := make(map[string]map[string]string)
+	for i := 0; i < 10; i++ {
+		p := fmt.Sprintf("%c.txt", 'a'+i)
+		metadata[p] = map[string]string{"Description": strings.Repeat(p, 200)}
+	}
+
+	t.Run("metadata moved out", func(t *testing.T) {
+		ls := &sizeCheckingSaver{LoadSaver: newMockLoadSaver(), max: mantaray.ChunkSize}
+		n := mantaray.New()
+		for p, md := range metadata {
+			if err := n.Add(ctx, []byte(p), testEntry(p), md, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := n.SaveWithOptions(ctx, ls, o); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		m := mantaray.NewNodeRef(n.Reference())
+		for p, md := range metadata {
+			node, err := m.LookupNode(ctx, []byte(p), ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(node.Metadata(), md) {
+				t.Fatalf("expected metadata of %s to be restored", p)
+			}
+		}
+	})
+
+	t.Run("metadata loaded lazily", func(t *testing.T) {
+		ls := newMockLoadSaver()
+		entries := make(map[string]map[string]string)
+		for p, md := range metadata {
+			entries[p] = md
+		}
+		ref := savedManifestMax(t, ls, entries, mantaray.ChunkSize)
+
+		rl := &recordingLoader{LoadSaver: ls, loads: make(map[string]int)}
+		node, err := mantaray.NewNodeRef(ref).LookupNode(ctx, []byte("a.txt"), rl)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(node.Metadata(), metadata["a.txt"]) {
+			t.Fatalf("expected metadata of a.txt to be loaded, got %v", node.Metadata())
+		}
+		// the root, the node of a.txt and its metadata only
+		if len(rl.loads) != 3 {
+			t.Fatalf("expected 3 loads, got %d", len(rl.loads))
+		}
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		ls := &sizeCheckingSaver{LoadSaver: newMockLoadSaver(), max: mantaray.ChunkSize}
+		n := mantaray.New()
+		for p, md := range metadata {
+			if err := n.Add(ctx, []byte(p), testEntry(p), md, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := n.Save(ctx, ls); err == nil {
+			t.Fatal("expected the node to exceed the chunk size")
+		}
+	})
+
+	t.Run("too many forks", func(t *testing.T) {
+		n := mantaray.New()
+		for i := 0; i < 100; i++ {
+			p := []byte{byte(i)}
+			if err := n.Add(ctx, p, testEntry(string(p)), nil, nil); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := n.SaveWithOptions(ctx, newMockLoadSaver(), o); !errors.Is(err, mantaray.ErrNodeTooLarge) {
+			t.Fatalf("expected node too large error, got %v", err)
+		}
+	})
+}
+
+func TestReservedMetadataKey(t *testing.T) {
+	ctx := context.Background()
+	metadata := map[string]string{"mantaray:metadata-ref": "00"}
+
+	n := mantaray.New()
+	if err := n.Add(ctx, []byte("a"), testEntry("a"), metadata, nil); !errors.Is(err, mantaray.ErrReservedMetadataKey) {
+		t.Fatalf("expected reserved metadata key error, got %v", err)
+	}
+	p := &mantaray.Patch{Ops: []mantaray.Op{{Type: mantaray.OpAdd, Path: []byte("a"), Entry: testEntry("a"), Metadata: metadata}}}
+	if err := n.Apply(ctx, p, nil); !errors.Is(err, mantaray.ErrReservedMetadataKey) {
+		t.Fatalf("expected reserved metadata key error, got %v", err)
+	}
+	if err := n.ApplyOps(ctx, p.Ops, nil); !errors.Is(err, mantaray.ErrReservedMetadataKey) {
+		t.Fatalf("expected reserved metadata key error, got %v", err)
+	}
+	if _, err := mantaray.NewSnapshot(n).Add(ctx, []byte("a"), testEntry("a"), metadata, nil); !errors.Is(err, mantaray.ErrReservedMetadataKey) {
+		t.Fatalf("expected reserved metadata key error, got %v", err)
+	}
+}
+
+// limitedSaver records concurrent saves and fails after a number of them.
+type limitedSaver struct {
+	mantaray.LoadSaver
+	failAfter int
+
+	mu      sync.Mutex
+	saves   int
+	current int
+	max     int
+}
+
+var errSaveFailed = errors.New("save failed")
+
+func (s *limitedSaver) Save(ctx context.Context, b []byte) ([]byte, error) {
+	s.mu.Lock()
+	if s.failAfter > 0 && s.saves >= s.failAfter {
+		s.mu.Unlock()
+		return nil, errSaveFailed
+	}
+	s.saves++
+	s.current++
+	if s.current > s.max {
+		s.max = s.current
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.current--
+		s.mu.Unlock()
+	}()
+	time.Sleep(100 * time.Microsecond)
+	return s.LoadSaver.Save(ctx, b)
+}
+
+func newTestTrie(t *testing.T) *mantaray.Node {
+	t.Helper()
+	n := mantaray.New()
+	n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+	for i := 0; i < 50; i++ {
+		p := fmt.Sprintf("dir%d/file%d", i%7, i)
+		if err := n.Add(context.Background(), []byte(p), testEntry(p), nil, nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	return n
+}
+
+func TestSaveWithOptions(t *testing.T) {
+	ctx := context.Background()
+	s := &limitedSaver{LoadSaver: newMockLoadSaver()}
+	var progress []mantaray.SaveProgress
+	n := newTestTrie(t)
+	err := n.SaveWithOptions(ctx, s, &mantaray.SaveOptions{
+		Concurrency: 3,
+		Progress: func(p mantaray.SaveProgress) {
+			progress = append(progress, p)
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if s.max > 3 {
+		t.Fatalf("expected at most 3 concurrent saves, got %d", s.max)
+	}
+	last := progress[len(progress)-1]
+	if last.Nodes != s.saves || last.TotalNodes != s.saves || len(progress) != s.saves {
+		t.Fatalf("expected %d saved nodes, got %+v after %d reports", s.saves, last, len(progress))
+	}
+	if last.Bytes == 0 {
+		t.Fatal("expected saved bytes to be reported")
+	}
+
+	// the reference does not depend on the limit
+	m := newTestTrie(t)
+	o := newTestTrie(t)
+	if err := m.SaveWithOptions(ctx, newMockLoadSaver(), &mantaray.SaveOptions{Concurrency: 1}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := o.Save(ctx, newMockLoadSaver()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(m.Reference(), o.Reference()) {
+		t.Fatalf("expected reference %x, got %x", o.Reference(), m.Reference())
+	}
+}
+
+func TestSaveWithOptionsResume(t *testing.T) {
+	ctx := context.Background()
+	all := &limitedSaver{LoadSaver: newMockLoadSaver()}
+	if err := newTestTrie(t).Save(ctx, all); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	ls := newMockLoadSaver()
+	n := newTestTrie(t)
+	failing := &limitedSaver{LoadSaver: ls, failAfter: 10}
+	if err := n.SaveWithOptions(ctx, failing, &mantaray.SaveOptions{Concurrency: 2}); !errors.Is(err, errSaveFailed) {
+		t.Fatalf("expected save error, got %v", err)
+	}
+
+	var total int
+	s := &limitedSaver{LoadSaver: ls}
+	err := n.SaveWithOptions(ctx, s, &mantaray.SaveOptions{
+		Progress: func(p mantaray.SaveProgress) { total = p.TotalNodes },
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if s.saves != total {
+		t.Fatalf("expected %d saves, got %d", total, s.saves)
+	}
+	if failing.saves+s.saves != all.saves {
+		t.Fatalf("expected every node to be saved once, got %d and %d", failing.saves, s.saves)
+	}
+}
+
+// batchLoadSaver counts the single and batch calls made to it.
+type batchLoadSaver struct {
+	mantaray.LoadSaver
+
+	mu                           sync.Mutex
+	loads, saves                 int
+	loadManyCalls, saveManyCalls int
+}
+
+func (ls *batchLoadSaver) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	ls.mu.Lock()
+	ls.loads++
+	ls.mu.Unlock()
+	return ls.LoadSaver.Load(ctx, ref)
+}
+
+func (ls *batchLoadSaver) Save(ctx context.Context, b []byte) ([]byte, error) {
+	ls.mu.Lock()
+	ls.saves++
+	ls.mu.Unlock()
+	return ls.LoadSaver.Save(ctx, b)
+}
+
+func (ls *batchLoadSaver) LoadMany(ctx context.Context, refs [][]byte) ([][]byte, error) {
+	ls.mu.Lock()
+	ls.loadManyCalls++
+	ls.mu.Unlock()
+	data := make([][]byte, len(refs))
+	for i, ref := range refs {
+		b, err := ls.LoadSaver.Load(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		data[i] = b
+	}
+	return data, nil
+}
+
+func (ls *batchLoadSaver) SaveMany(ctx context.Context, data [][]byte) ([][]byte, error) {
+	ls.mu.Lock()
+	ls.saveManyCalls++
+	ls.mu.Unlock()
+	refs := make([][]byte, len(data))
+	for i, b := range data {
+		ref, err := ls.LoadSaver.Save(ctx, b)
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = ref
+	}
+	return refs, nil
+}
+
+func TestBatchSave(t *testing.T) {
+	ctx := context.Background()
+	want := newTestTrie(t)
+	if err := want.Save(ctx, newMockLoadSaver()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	ls := &batchLoadSaver{LoadSaver: newMockLoadSaver()}
+	var progress int
+	n := newTestTrie(t)
+	err := n.SaveWithOptions(ctx, ls, &mantaray.SaveOptions{
+		Progress: func(p mantaray.SaveProgress) {
+			progress = p.Nodes
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(n.Reference(), want.Reference()) {
+		t.Fatalf("expected reference %x, got %x", want.Reference(), n.Reference())
+	}
+	if ls.saves != 0 {
+		t.Fatalf("expected no single saves, got %d", ls.saves)
+	}
+	// one call per level of the trie
+	if ls.saveManyCalls == 0 || ls.saveManyCalls >= progress {
+		t.Fatalf("expected fewer batch saves than the %d saved nodes, got %d", progress, ls.saveManyCalls)
+	}
+}
+
+func TestBatchLoad(t *testing.T) {
+	ctx := context.Background()
+	mls := newMockLoadSaver()
+	ref := savedManifest(t, mls, map[string]map[string]string{
+		"a/1.txt": nil,
+		"a/2.txt": nil,
+		"b/1.txt": nil,
+		"b/2.txt": nil,
+		"c.txt":   nil,
+	})
+	changed := savedManifest(t, mls, map[string]map[string]string{
+		"a/1.txt": nil,
+		"a/2.txt": nil,
+		"b/1.txt": {"k": "v"},
+		"b/3.txt": nil,
+	})
+
+	var want []string
+	walkFn := func(visits *[]string) mantaray.WalkFunc {
+		return func(path []byte, _ bool, err error) error {
+			*visits = append(*visits, string(path))
+			return err
+		}
+	}
+	if err := mantaray.NewNodeRef(ref).Walk(ctx, []byte{}, mls, walkFn(&want)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ls := &batchLoadSaver{LoadSaver: mls}
+	var got []string
+	if err := mantaray.NewNodeRef(ref).Walk(ctx, []byte{}, ls, walkFn(&got)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected visits %q, got %q", want, got)
+	}
+	if ls.loads != 1 || ls.loadManyCalls == 0 {
+		t.Fatalf("expected only the root to be loaded singly, got %d single and %d batch loads", ls.loads, ls.loadManyCalls)
+	}
+
+	diffFn := func(diffs *[]string) mantaray.DiffFunc {
+		return func(path []byte, dt mantaray.DiffType, _, _ *mantaray.Node) error {
+			*diffs = append(*diffs, fmt.Sprintf("%s %s", dt, path))
+			return nil
+		}
+	}
+	var wantDiffs []string
+	if err := mantaray.Diff(ctx, mantaray.NewNodeRef(ref), mantaray.NewNodeRef(changed), mls, diffFn(&wantDiffs)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ls = &batchLoadSaver{LoadSaver: mls}
+	var gotDiffs []string
+	if err := mantaray.Diff(ctx, mantaray.NewNodeRef(ref), mantaray.NewNodeRef(changed), ls, diffFn(&gotDiffs)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(gotDiffs, wantDiffs) {
+		t.Fatalf("expected diffs %q, got %q", wantDiffs, gotDiffs)
+	}
+	if ls.loads != 2 || ls.loadManyCalls == 0 {
+		t.Fatalf("expected only the roots to be loaded singly, got %d single and %d batch loads", ls.loads, ls.loadManyCalls)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidProof proof does not match the root reference or path
+	ErrInvalidProof = errors.New("invalid proof")
+	// ErrNotSaved node has no reference to prove against, matching ErrInvalid
+	ErrNotSaved = fmt.Errorf("node not saved: %w", ErrInvalid)
+)
+
+// Prove returns the serialised nodes along the lookup of path in the trie
+// rooted at root, starting with the root and ending with the node at
+// path. If the metadata of that node was saved separately from its parent,
+// the metadata blob follows. The nodes are read from l, so the root must be
+// saved.
+func Prove(ctx context.Context, root *Node, path []byte, l Loader) ([][]byte, error) {
+	if root.ref == nil {
+		return nil, ErrNotSaved
+	}
+	if l == nil {
+		return nil, &LoadError{Op: "prove", Ref: root.ref, Err: ErrNoLoader}
+	}
+	var proof [][]byte
+	ref, rest := root.ref, path
+	var metadata map[string]string
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		data, err := l.Load(ctx, ref)
+		if err != nil {
+			return nil, &LoadError{Op: "prove", Path: copyBytes(path[:len(path)-len(rest)]), Ref: ref, Err: err}
+		}
+		proof = append(proof, data)
+		n := &Node{ref: ref}
+		if err := n.UnmarshalBinary(data); err != nil {
+			return nil, &LoadError{Op: "prove", Path: copyBytes(path[:len(path)-len(rest)]), Ref: ref, Err: err}
+		}
+		if len(rest) == 0 {
+			break
+		}
+		f := n.forks[rest[0]]
+		if f == nil || !bytes.HasPrefix(rest, f.prefix) {
+			return nil, notFound("prove", path, ref)
+		}
+		ref, rest, metadata = f.Node.ref, rest[len(f.prefix):], f.Node.metadata
+	}
+	if isMetadataRef(metadata) {
+		mref, err := hex.DecodeString(metadata[metadataRefKey])
+		if err != nil {
+			return nil, &LoadError{Op: "prove", Path: copyBytes(path), Ref: ref, Err: err}
+		}
+		data, err := l.Load(ctx, mref)
+		if err != nil {
+			return nil, &LoadError{Op: "prove", Path: copyBytes(path), Ref: mref, Err: err}
+		}
+		proof = append(proof, data)
+	}
+	return proof, nil
+}
+
+// VerifyProof checks a proof returned by Prove against the root reference
+// and returns the node at path, with its entry, type and metadata. The
+// hash of every serialised node must match the reference that leads to it.
+// If the proof shows that the root does not contain path, the error
+// matches ErrNotFound.
+func VerifyProof(rootRef, path []byte, proof [][]byte, hash HashFunc) (*Node, error) {
+	ref, rest := rootRef, path
+	var parent *Node
+	for i, data := range proof {
+		if !bytes.Equal(hash(data), ref) {
+			return nil, fmt.Errorf("node %d does not match reference %x: %w", i, ref, ErrInvalidProof)
+		}
+		n := &Node{ref: ref}
+		if parent != nil {
+			// the type and metadata are kept on the parent fork
+			n.nodeType, n.metadata = parent.nodeType, parent.metadata
+		}
+		if err := n.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("node %d: %v: %w", i, err, ErrInvalidProof)
+		}
+		if len(rest) == 0 {
+			if err := verifyProofMetadata(n, proof[i+1:], hash); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+		f := n.forks[rest[0]]
+		if f == nil || !bytes.HasPrefix(rest, f.prefix) {
+			if i != len(proof)-1 {
+				return nil, fmt.Errorf("nodes after node %d: %w", i, ErrInvalidProof)
+			}
+			return nil, notFound("verify proof", path, ref)
+		}
+		ref, rest, parent = f.Node.ref, rest[len(f.prefix):], f.Node
+	}
+	return nil, fmt.Errorf("missing nodes for '%s': %w", rest, ErrInvalidProof)
+}
+
+// verifyProofMetadata checks the part of a proof that follows the node at
+// the proven path, restoring the metadata of n if it was saved separately.
+func verifyProofMetadata(n *Node, rest [][]byte, hash HashFunc) error {
+	if !isMetadataRef(n.metadata) {
+		if len(rest) > 0 {
+			return fmt.Errorf("%d unexpected blobs: %w", len(rest), ErrInvalidProof)
+		}
+		return nil
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("%d metadata blobs: %w", len(rest), ErrInvalidProof)
+	}
+	ref, err := hex.DecodeString(n.metadata[metadataRefKey])
+	if err != nil {
+		return fmt.Errorf("metadata reference: %v: %w", err, ErrInvalidProof)
+	}
+	if !bytes.Equal(hash(rest[0]), ref) {
+		return fmt.Errorf("metadata does not match reference %x: %w", ref, ErrInvalidProof)
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal(rest[0], &metadata); err != nil {
+		return fmt.Errorf("metadata: %v: %w", err, ErrInvalidProof)
+	}
+	n.metadata = metadata
+	return nil
+}
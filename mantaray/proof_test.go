@@ -0,0 +1,124 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func TestProof(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	entries := map[string]map[string]string{
+		"index.html": {"Content-Type": "text/html"},
+		"img/1.png":  nil,
+		"img/2.png":  nil,
+		"doc/a.txt":  {"Description": strings.Repeat("a", 8000)},
+	}
+	ref := savedManifestMax(t, ls, entries, mantaray.ChunkSize)
+
+	for p, metadata := range entries {
+		proof, err := mantaray.Prove(ctx, mantaray.NewNodeRef(ref), []byte(p), ls)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", p, err)
+		}
+		n, err := mantaray.VerifyProof(ref, []byte(p), proof, sha256Hash)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", p, err)
+		}
+		if !n.IsValueType() || !bytes.Equal(n.Entry(), testEntry(p)) {
+			t.Fatalf("%s: unexpected entry %x", p, n.Entry())
+		}
+		if len(metadata) > 0 && !reflect.DeepEqual(n.Metadata(), metadata) {
+			t.Fatalf("%s: expected metadata %v, got %v", p, metadata, n.Metadata())
+		}
+	}
+
+	_, err := mantaray.Prove(ctx, mantaray.NewNodeRef(ref), []byte("img/3.png"), ls)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	proof, err := mantaray.Prove(ctx, mantaray.NewNodeRef(ref), []byte("img/1.png"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	t.Run("tampered", func(t *testing.T) {
+		tampered := append([][]byte(nil), proof...)
+		last := append([]byte(nil), tampered[len(tampered)-1]...)
+		last[64] ^= 0xff
+		tampered[len(tampered)-1] = last
+		_, err := mantaray.VerifyProof(ref, []byte("img/1.png"), tampered, sha256Hash)
+		if !errors.Is(err, mantaray.ErrInvalidProof) {
+			t.Fatalf("expected invalid proof error, got %v", err)
+		}
+	})
+
+	t.Run("tampered metadata", func(t *testing.T) {
+		proof, err := mantaray.Prove(ctx, mantaray.NewNodeRef(ref), []byte("doc/a.txt"), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		tampered := append([][]byte(nil), proof...)
+		tampered[len(tampered)-1] = []byte(`{"Description":"b"}`)
+		n, err := mantaray.VerifyProof(ref, []byte("doc/a.txt"), tampered, sha256Hash)
+		if !errors.Is(err, mantaray.ErrInvalidProof) {
+			t.Fatalf("expected invalid proof error, got %v", err)
+		}
+		if n != nil {
+			t.Fatalf("expected no node from a rejected proof, got %v", n.Metadata())
+		}
+	})
+
+	t.Run("other root", func(t *testing.T) {
+		_, err := mantaray.VerifyProof(sha256Hash(nil), []byte("img/1.png"), proof, sha256Hash)
+		if !errors.Is(err, mantaray.ErrInvalidProof) {
+			t.Fatalf("expected invalid proof error, got %v", err)
+		}
+	})
+
+	t.Run("other path", func(t *testing.T) {
+		_, err := mantaray.VerifyProof(ref, []byte("img/2.png"), proof, sha256Hash)
+		if !errors.Is(err, mantaray.ErrInvalidProof) {
+			t.Fatalf("expected invalid proof error, got %v", err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := mantaray.VerifyProof(ref, []byte("img/1.png"), proof[:len(proof)-1], sha256Hash)
+		if !errors.Is(err, mantaray.ErrInvalidProof) {
+			t.Fatalf("expected invalid proof error, got %v", err)
+		}
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		// the root alone shows that no path starts with 'x'
+		_, err := mantaray.VerifyProof(ref, []byte("x.txt"), proof[:1], sha256Hash)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("expected not found error, got %v", err)
+		}
+	})
+}
+
+func TestProveNotSaved(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := mantaray.New()
+	if err := n.Add(ctx, []byte("index.html"), testEntry("index.html"), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_, err := mantaray.Prove(ctx, n, []byte("index.html"), ls)
+	if !errors.Is(err, mantaray.ErrNotSaved) || !errors.Is(err, mantaray.ErrInvalid) {
+		t.Fatalf("expected not saved error, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+)
+
+// RefKind tells what a reference reported by References points to.
+type RefKind int
+
+const (
+	// RefNode marks the reference of a serialised node.
+	RefNode RefKind = iota + 1
+	// RefEntry marks the entry of a node.
+	RefEntry
+	// RefMetadata marks the reference of fork metadata saved separately
+	// from an oversized node.
+	RefMetadata
+)
+
+func (k RefKind) String() string {
+	switch k {
+	case RefNode:
+		return "node"
+	case RefEntry:
+		return "entry"
+	case RefMetadata:
+		return "metadata"
+	}
+	return "unknown"
+}
+
+// RefFunc is the type of the function called for each reference reported
+// by References.
+type RefFunc func(ref []byte, kind RefKind) error
+
+// References calls fn once for every distinct reference reachable from
+// root: the references of saved nodes, of separately saved metadata and
+// the non-zero entries. Saved nodes are read from l without being kept in
+// memory, and nodes without a reference are visited as they are in memory.
+func References(ctx context.Context, root *Node, l Loader, fn RefFunc) error {
+	w := &refWalker{l: l, fn: fn, seen: make(map[string]struct{})}
+	return w.walk(ctx, root)
+}
+
+// ReferencesExcept is like References, but only reports the references
+// reachable from root that are not reachable from except. Subtrees shared
+// with except are not loaded. After root is replaced by except, the
+// reported references are no longer needed by either trie.
+func ReferencesExcept(ctx context.Context, root, except *Node, l Loader, fn RefFunc) error {
+	seen := make(map[string]struct{})
+	w := &refWalker{l: l, fn: func([]byte, RefKind) error { return nil }, seen: seen}
+	if err := w.walk(ctx, except); err != nil {
+		return err
+	}
+	w = &refWalker{l: l, fn: fn, seen: seen}
+	return w.walk(ctx, root)
+}
+
+// refWalker reports the references of a trie that are not in seen.
+type refWalker struct {
+	l    Loader
+	fn   RefFunc
+	seen map[string]struct{}
+}
+
+// report calls fn with ref unless it was seen before.
+func (w *refWalker) report(ref []byte, kind RefKind) (bool, error) {
+	if _, ok := w.seen[string(ref)]; ok {
+		return false, nil
+	}
+	w.seen[string(ref)] = struct{}{}
+	return true, w.fn(ref, kind)
+}
+
+func (w *refWalker) walk(ctx context.Context, n *Node) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if n.ref != nil {
+		// a node seen before has the same subtree
+		reported, err := w.report(n.ref, RefNode)
+		if err != nil || !reported {
+			return err
+		}
+		// the stored node keeps the references of spilled metadata
+		if w.l == nil {
+			return ErrNoLoader
+		}
+		b, err := w.l.Load(ctx, n.ref)
+		if err != nil {
+			return err
+		}
+		c := &Node{ref: n.ref}
+		if err := c.UnmarshalBinary(b); err != nil {
+			return err
+		}
+		n = c
+	}
+	if len(n.entry) > 0 && !isZero(n.entry) {
+		if _, err := w.report(n.entry, RefEntry); err != nil {
+			return err
+		}
+	}
+	for _, k := range sortedKeys(n.forks) {
+		f := n.forks[k]
+		if n.ref != nil && isMetadataRef(f.Node.metadata) {
+			ref, err := hex.DecodeString(f.Node.metadata[metadataRefKey])
+			if err != nil {
+				return fmt.Errorf("metadata reference on fork '%x': %w", []byte{k}, err)
+			}
+			if _, err := w.report(ref, RefMetadata); err != nil {
+				return err
+			}
+		}
+		if err := w.walk(ctx, f.Node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
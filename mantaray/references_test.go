@@ -0,0 +1,131 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func collectReferences(t *testing.T, f func(mantaray.RefFunc) error) map[mantaray.RefKind]map[string]bool {
+	t.Helper()
+	refs := make(map[mantaray.RefKind]map[string]bool)
+	err := f(func(ref []byte, kind mantaray.RefKind) error {
+		if refs[kind] == nil {
+			refs[kind] = make(map[string]bool)
+		}
+		if refs[kind][string(ref)] {
+			return fmt.Errorf("reference %x reported twice", ref)
+		}
+		refs[kind][string(ref)] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return refs
+}
+
+func TestReferences(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	entries := map[string]map[string]string{
+		"index.html": nil,
+		"img/1.png":  nil,
+		"img/2.png":  nil,
+	}
+	for i := 0; i < 10; i++ {
+		p := fmt.Sprintf("doc/%c.txt", 'a'+i)
+		entries[p] = map[string]string{"Description": strings.Repeat(p, 100)}
+	}
+	ref := savedManifestMax(t, ls, entries, mantaray.ChunkSize)
+
+	refs := collectReferences(t, func(fn mantaray.RefFunc) error {
+		return mantaray.References(ctx, mantaray.NewNodeRef(ref), ls, fn)
+	})
+	if len(refs[mantaray.RefMetadata]) == 0 {
+		t.Fatal("expected metadata references")
+	}
+	// the store holds exactly the nodes and metadata of the manifest
+	stored := make(map[string]bool)
+	for a := range ls.store {
+		stored[string(a[:])] = true
+	}
+	saved := make(map[string]bool)
+	for _, kind := range []mantaray.RefKind{mantaray.RefNode, mantaray.RefMetadata} {
+		for r := range refs[kind] {
+			saved[r] = true
+		}
+	}
+	if !reflect.DeepEqual(saved, stored) {
+		t.Fatalf("expected %d stored references, got %d", len(stored), len(saved))
+	}
+	wantEntries := make(map[string]bool)
+	for p := range entries {
+		wantEntries[string(testEntry(p))] = true
+	}
+	if !reflect.DeepEqual(refs[mantaray.RefEntry], wantEntries) {
+		t.Fatalf("expected %d entry references, got %d", len(wantEntries), len(refs[mantaray.RefEntry]))
+	}
+}
+
+func TestReferencesExcept(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	old := savedManifest(t, ls, map[string]map[string]string{
+		"index.html": nil,
+		"img/1.png":  nil,
+		"img/2.png":  nil,
+		"css/a.css":  nil,
+	})
+	updated := savedManifest(t, ls, map[string]map[string]string{
+		"index.html": nil,
+		"img/1.png":  nil,
+		"img/3.png":  nil,
+		"css/a.css":  nil,
+	})
+
+	all := func(ref []byte) map[string]bool {
+		refs := make(map[string]bool)
+		for _, set := range collectReferences(t, func(fn mantaray.RefFunc) error {
+			return mantaray.References(ctx, mantaray.NewNodeRef(ref), ls, fn)
+		}) {
+			for r := range set {
+				refs[r] = true
+			}
+		}
+		return refs
+	}
+	oldRefs, newRefs := all(old), all(updated)
+
+	got := make(map[string]bool)
+	for _, set := range collectReferences(t, func(fn mantaray.RefFunc) error {
+		return mantaray.ReferencesExcept(ctx, mantaray.NewNodeRef(old), mantaray.NewNodeRef(updated), ls, fn)
+	}) {
+		for r := range set {
+			got[r] = true
+		}
+	}
+	want := make(map[string]bool)
+	for r := range oldRefs {
+		if !newRefs[r] {
+			want[r] = true
+		}
+	}
+	if len(want) == 0 {
+		t.Fatal("expected references only reachable from the old manifest")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %d unreachable references, got %d", len(want), len(got))
+	}
+	if !got[string(testEntry("img/2.png"))] {
+		t.Fatal("expected the removed entry to be reported")
+	}
+}
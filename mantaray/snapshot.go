@@ -0,0 +1,294 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Snapshot is an immutable manifest. Add and Remove return a new Snapshot
+// that shares all unchanged nodes with the old one, which stays readable.
+// Nodes reachable from a Snapshot are never modified, not even to keep
+// lazily loaded forks, so a Snapshot is safe for concurrent use.
+type Snapshot struct {
+	root *Node
+}
+
+// NewSnapshot returns a Snapshot of the manifest rooted at root. The caller
+// must not modify root afterwards.
+func NewSnapshot(root *Node) *Snapshot {
+	return &Snapshot{root: root}
+}
+
+// Reference returns the address of the snapshot root if saved.
+func (s *Snapshot) Reference() []byte {
+	return s.root.ref
+}
+
+// LookupNode finds the node for a path or returns error if not found.
+// The returned node must not be modified.
+func (s *Snapshot) LookupNode(ctx context.Context, path []byte, l Loader) (*Node, error) {
+	n, rest := s.root, path
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		ln, err := n.loaded(ctx, l)
+		if err != nil {
+			return nil, &LoadError{Op: "lookup", Path: copyBytes(path[:len(path)-len(rest)]), Ref: n.ref, Err: err}
+		}
+		n = ln
+		if len(rest) == 0 {
+			if !isMetadataRef(n.metadata) {
+				return n, nil
+			}
+			// nodes are shared between snapshots, so the loaded metadata
+			// is set on a copy
+			metadata, err := loadMetadata(ctx, l, n.metadata)
+			if err != nil {
+				return nil, &LoadError{Op: "lookup", Path: copyBytes(path), Ref: n.ref, Err: err}
+			}
+			c := *n
+			c.metadata = metadata
+			return &c, nil
+		}
+		f := n.forks[rest[0]]
+		if f == nil || !bytes.HasPrefix(rest, f.prefix) {
+			return nil, notFound("lookup", path, n.ref)
+		}
+		rest = rest[len(f.prefix):]
+		n = f.Node
+	}
+}
+
+// Lookup finds the entry for a path or returns error if not found
+func (s *Snapshot) Lookup(ctx context.Context, path []byte, l Loader) ([]byte, error) {
+	n, err := s.LookupNode(ctx, path, l)
+	if err != nil {
+		return nil, err
+	}
+	return n.entry, nil
+}
+
+// Add returns a new Snapshot with the entry added to the path.
+func (s *Snapshot) Add(ctx context.Context, path, entry []byte, metadata map[string]string, l Loader) (*Snapshot, error) {
+	if err := checkMetadata(metadata); err != nil {
+		return nil, err
+	}
+	root, err := s.root.with(ctx, path, entry, metadata, l)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{root: root}, nil
+}
+
+// Remove returns a new Snapshot with the path removed.
+func (s *Snapshot) Remove(ctx context.Context, path []byte, l Loader) (*Snapshot, error) {
+	root, err := s.root.without(ctx, path, l)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{root: root}, nil
+}
+
+// Save persists the nodes not yet saved and returns a Snapshot with the
+// same content whose root has a reference.
+func (s *Snapshot) Save(ctx context.Context, sv Saver) (*Snapshot, error) {
+	return s.SaveWithOptions(ctx, sv, nil)
+}
+
+// SaveWithOptions is like Save. Only the MaxNodeSize option is used.
+func (s *Snapshot) SaveWithOptions(ctx context.Context, sv Saver, o *SaveOptions) (*Snapshot, error) {
+	if sv == nil {
+		return nil, &SaveError{Op: "save", Err: ErrNoSaver}
+	}
+	if o == nil {
+		o = &SaveOptions{}
+	}
+	root, err := s.root.saved(ctx, sv, o.MaxNodeSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{root: root}, nil
+}
+
+// loaded returns n if its forks are in memory, otherwise a loaded copy.
+func (n *Node) loaded(ctx context.Context, l Loader) (*Node, error) {
+	if n.forks != nil {
+		return n, nil
+	}
+	c := *n
+	if err := c.load(ctx, l); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// edit returns a loaded copy of n that can be modified.
+func (n *Node) edit(ctx context.Context, l Loader) (*Node, error) {
+	ln, err := n.loaded(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+	c := *ln
+	c.ref = nil
+	c.forks = make(map[byte]*fork, len(ln.forks))
+	for k, f := range ln.forks {
+		c.forks[k] = f
+	}
+	return &c, nil
+}
+
+// with is the copy-on-write counterpart of Add.
+func (n *Node) with(ctx context.Context, path, entry []byte, metadata map[string]string, l Loader) (*Node, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	nn, err := n.edit(ctx, l)
+	if err != nil {
+		return nil, &LoadError{Op: "add", Ref: n.ref, Err: err}
+	}
+	if err := nn.checkEntrySize(entry); err != nil {
+		return nil, err
+	}
+
+	if len(path) == 0 {
+		nn.entry = entry
+		nn.makeValue()
+		if len(metadata) > 0 {
+			nn.metadata = metadata
+			nn.makeWithMetadata()
+		}
+		return nn, nil
+	}
+	f := nn.forks[path[0]]
+	if f == nil {
+		child := nn.newChild()
+		// check for prefix size limit
+		if len(path) > nodePrefixMaxSize {
+			prefix := path[:nodePrefixMaxSize]
+			rest := path[nodePrefixMaxSize:]
+			child, err = child.with(ctx, rest, entry, metadata, l)
+			if err != nil {
+				return nil, prependPath(prefix, err)
+			}
+			child.updateIsWithPathSeparator(prefix)
+			nn.forks[path[0]] = &fork{prefix, child}
+			nn.makeEdge()
+			return nn, nil
+		}
+		child.entry = entry
+		if len(metadata) > 0 {
+			child.metadata = metadata
+			child.makeWithMetadata()
+		}
+		child.makeValue()
+		child.updateIsWithPathSeparator(path)
+		nn.forks[path[0]] = &fork{path, child}
+		nn.makeEdge()
+		return nn, nil
+	}
+	c := common(f.prefix, path)
+	rest := f.prefix[len(c):]
+	child := f.Node
+	if len(rest) > 0 {
+		// move a copy of the current node under the common prefix
+		moved := *f.Node
+		moved.updateIsWithPathSeparator(rest)
+		child = nn.newChild()
+		child.forks[rest[0]] = &fork{rest, &moved}
+		child.makeEdge()
+		// if common path is full path new node is value type
+		if len(path) == len(c) {
+			child.makeValue()
+		}
+	}
+	child, err = child.with(ctx, path[len(c):], entry, metadata, l)
+	if err != nil {
+		return nil, prependPath(c, err)
+	}
+	// NOTE: special case on edge split
+	child.updateIsWithPathSeparator(path)
+	nn.forks[path[0]] = &fork{c, child}
+	nn.makeEdge()
+	return nn, nil
+}
+
+// without is the copy-on-write counterpart of Remove.
+func (n *Node) without(ctx context.Context, path []byte, l Loader) (*Node, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if len(path) == 0 {
+		return nil, ErrEmptyPath
+	}
+	nn, err := n.edit(ctx, l)
+	if err != nil {
+		return nil, &LoadError{Op: "remove", Ref: n.ref, Err: err}
+	}
+	f := nn.forks[path[0]]
+	if f == nil || !bytes.HasPrefix(path, f.prefix) {
+		return nil, notFound("remove", path, n.ref)
+	}
+	rest := path[len(f.prefix):]
+	if len(rest) == 0 {
+		// full path matched
+		delete(nn.forks, path[0])
+		return nn, nil
+	}
+	child, err := f.Node.without(ctx, rest, l)
+	if err != nil {
+		return nil, prependPath(f.prefix, err)
+	}
+	nn.forks[path[0]] = &fork{f.prefix, child}
+	return nn, nil
+}
+
+// saved is the copy-on-write counterpart of save. Forks are kept in memory
+// so that the returned node shares them with n.
+func (n *Node) saved(ctx context.Context, s Saver, maxSize int) (*Node, error) {
+	if n.ref != nil {
+		return n, nil
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	c := *n
+	c.forks = make(map[byte]*fork, len(n.forks))
+	eg, ectx := errgroup.WithContext(ctx)
+	for k, f := range n.forks {
+		cf := &fork{prefix: f.prefix, Node: f.Node}
+		c.forks[k] = cf
+		eg.Go(func() (err error) {
+			cf.Node, err = cf.Node.saved(ectx, s, maxSize)
+			return prependPath(cf.prefix, err)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	bytes, err := c.marshal(ctx, s, maxSize)
+	if err != nil {
+		return nil, &SaveError{Op: "save", Err: err}
+	}
+	ref, err := s.Save(ctx, bytes)
+	if err != nil {
+		return nil, &SaveError{Op: "save", Ref: ref, Err: err}
+	}
+	c.ref = ref
+	c.touched = nil
+	return &c, nil
+}
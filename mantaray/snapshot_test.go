@@ -0,0 +1,174 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	paths := []string{"index.html", "img/1.png", "img/2.png", "robots.txt"}
+	s := mantaray.NewSnapshot(mantaray.New())
+	var snapshots []*mantaray.Snapshot
+	for _, p := range paths {
+		var err error
+		s, err = s.Add(ctx, []byte(p), testEntry(p), nil, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		snapshots = append(snapshots, s)
+	}
+	for i, s := range snapshots {
+		for j, p := range paths {
+			_, err := s.Lookup(ctx, []byte(p), ls)
+			if j <= i && err != nil {
+				t.Fatalf("snapshot %d: expected %s, got %v", i, p, err)
+			}
+			if j > i && !errors.Is(err, mantaray.ErrNotFound) {
+				t.Fatalf("snapshot %d: expected %s not to be found, got %v", i, p, err)
+			}
+		}
+	}
+
+	saved, err := s.Save(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if s.Reference() != nil {
+		t.Fatalf("expected unsaved snapshot to stay unsaved")
+	}
+
+	removed, err := mantaray.NewSnapshot(mantaray.NewNodeRef(saved.Reference())).Remove(ctx, []byte("img/1.png"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := removed.Lookup(ctx, []byte("img/1.png"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if _, err := saved.Lookup(ctx, []byte("img/1.png"), ls); err != nil {
+		t.Fatalf("expected old snapshot to keep entry, got %v", err)
+	}
+
+	// the same content added with Node.Add is equal
+	n := mantaray.New()
+	for _, p := range paths {
+		if err := n.Add(ctx, []byte(p), testEntry(p), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = mantaray.Diff(ctx, mantaray.NewNodeRef(n.Reference()), mantaray.NewNodeRef(saved.Reference()), ls, func(path []byte, _ mantaray.DiffType, _, _ *mantaray.Node) error {
+		return fmt.Errorf("unexpected difference on %s", path)
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestSnapshotConcurrentReaders(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	n := mantaray.New()
+	var paths []string
+	for i := 0; i < 50; i++ {
+		p := fmt.Sprintf("dir%d/file%02d.txt", i%5, i)
+		paths = append(paths, p)
+		if err := n.Add(ctx, []byte(p), testEntry(p), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	old := mantaray.NewSnapshot(mantaray.NewNodeRef(n.Reference()))
+
+	var wg sync.WaitGroup
+	errc := make(chan error, 10)
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, p := range paths {
+				e, err := old.Lookup(ctx, []byte(p), ls)
+				if err != nil {
+					errc <- err
+					return
+				}
+				if !bytes.Equal(e, testEntry(p)) {
+					errc <- fmt.Errorf("expected entry %x, got %x", testEntry(p), e)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s := old
+		for _, p := range paths {
+			var err error
+			if s, err = s.Remove(ctx, []byte(p), ls); err != nil {
+				errc <- err
+				return
+			}
+			if s, err = s.Save(ctx, ls); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotMaxNodeSize(t *testing.T) {
+	ctx := context.Background()
+	ls := &sizeCheckingSaver{LoadSaver: newMockLoadSaver(), max: mantaray.ChunkSize}
+	s := mantaray.NewSnapshot(mantaray.New())
+	metadata := make(map[string]map[string]string)
+	for i := 0; i < 10; i++ {
+		p := fmt.Sprintf("doc/%c.txt", 'a'+i)
+		metadata[p] = map[string]string{"Description": strings.Repeat(p, 100)}
+		var err error
+		if s, err = s.Add(ctx, []byte(p), testEntry(p), metadata[p], ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if _, err := s.Save(ctx, ls); err == nil {
+		t.Fatal("expected a node larger than a chunk without a limit")
+	}
+	saved, err := s.SaveWithOptions(ctx, ls, &mantaray.SaveOptions{MaxNodeSize: mantaray.ChunkSize})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n := mantaray.NewNodeRef(saved.Reference())
+	for p, md := range metadata {
+		nn, err := n.LookupNode(ctx, []byte(p), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(nn.Metadata(), md) {
+			t.Fatalf("%s: unexpected metadata %v", p, nn.Metadata())
+		}
+	}
+}
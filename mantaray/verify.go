@@ -0,0 +1,200 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrHashMismatch reference does not address the loaded content
+	ErrHashMismatch = errors.New("reference does not match content hash")
+	// ErrInvalidNode node breaks a structural invariant
+	ErrInvalidNode = errors.New("invalid node")
+)
+
+// HashFunc returns the reference of data in the store a trie is saved to.
+type HashFunc func(data []byte) []byte
+
+// Violation is a problem found by Verify.
+type Violation struct {
+	// Path is the path of the node with the problem.
+	Path []byte
+	// Ref is the reference of the node or metadata blob, if saved.
+	Ref []byte
+	// Err describes the problem.
+	Err error
+}
+
+func (v Violation) String() string {
+	if v.Ref == nil {
+		return fmt.Sprintf("'%s': %v", v.Path, v.Err)
+	}
+	return fmt.Sprintf("'%s' (%x): %v", v.Path, v.Ref, v.Err)
+}
+
+// Report is the result of Verify.
+type Report struct {
+	// Nodes is the number of nodes checked.
+	Nodes int
+	// Violations lists the problems found in lexicographic path order.
+	Violations []Violation
+}
+
+// OK reports whether no problems were found.
+func (r *Report) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// Verify loads every node of the trie rooted at root and checks that the
+// saved nodes and metadata blobs match the hash of their content and that
+// the nodes are well formed:
+//   - fork prefixes are between 1 and 30 bytes long and start with their
+//     fork byte,
+//   - a prefix with a path separator after its first byte is flagged so,
+//   - nodes with forks have the edge flag and nodes without the value flag
+//     have no entry,
+//   - entries and the references of saved nodes are refBytesSize long,
+//   - the metadata flag matches the metadata, which fits in a fork.
+//
+// A node that cannot be loaded is reported and its subtree is skipped.
+// Saved nodes are read from l without being kept in memory. An error is
+// only returned if the verification could not complete.
+func Verify(ctx context.Context, root *Node, l Loader, hash HashFunc) (*Report, error) {
+	v := &verifier{l: l, hash: hash, report: &Report{}}
+	if err := v.verify(ctx, []byte{}, root, true); err != nil {
+		return nil, err
+	}
+	return v.report, nil
+}
+
+type verifier struct {
+	l      Loader
+	hash   HashFunc
+	report *Report
+}
+
+func (v *verifier) add(path, ref []byte, err error) {
+	v.report.Violations = append(v.report.Violations, Violation{Path: path, Ref: ref, Err: err})
+}
+
+// load returns the data of ref, reporting load failures and hash
+// mismatches at path. Nil data is returned if the data could not be
+// loaded.
+func (v *verifier) load(ctx context.Context, path, ref []byte) ([]byte, error) {
+	if v.l == nil {
+		return nil, ErrNoLoader
+	}
+	b, err := v.l.Load(ctx, ref)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		v.add(path, ref, err)
+		return nil, nil
+	}
+	if h := v.hash(b); !bytes.Equal(h, ref) {
+		v.add(path, ref, fmt.Errorf("content hash %x: %w", h, ErrHashMismatch))
+	}
+	return b, nil
+}
+
+func (v *verifier) verify(ctx context.Context, path []byte, n *Node, isRoot bool) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if n.ref != nil {
+		b, err := v.load(ctx, path, n.ref)
+		if err != nil || b == nil {
+			return err
+		}
+		// the type and metadata are kept on the parent fork
+		c := &Node{ref: n.ref, nodeType: n.nodeType, metadata: n.metadata}
+		if err := c.UnmarshalBinary(b); err != nil {
+			v.add(path, n.ref, fmt.Errorf("%v: %w", err, ErrInvalidNode))
+			return nil
+		}
+		n = c
+	}
+	v.report.Nodes++
+
+	// the type of a loaded root is not persisted
+	if !isRoot {
+		if len(n.forks) > 0 && !n.IsEdgeType() {
+			v.add(path, n.ref, fmt.Errorf("forks without edge flag: %w", ErrInvalidNode))
+		}
+		if !n.IsValueType() && !isZero(n.entry) {
+			v.add(path, n.ref, fmt.Errorf("entry without value flag: %w", ErrInvalidNode))
+		}
+	}
+	if len(n.entry) > 0 && len(n.entry) != n.refBytesSize {
+		v.add(path, n.ref, fmt.Errorf("entry size %d, expected %d: %w", len(n.entry), n.refBytesSize, ErrInvalidNode))
+	}
+
+	for _, k := range sortedKeys(n.forks) {
+		f := n.forks[k]
+		childPath := appendPath(path, f.prefix)
+		if err := v.verifyFork(ctx, childPath, n, k, f); err != nil {
+			return err
+		}
+		if err := v.verify(ctx, childPath, f.Node, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyFork checks the fork of n on byte k leading to path.
+func (v *verifier) verifyFork(ctx context.Context, path []byte, n *Node, k byte, f *fork) error {
+	child := f.Node
+	// references of forks are serialised with the size of the entries
+	if child.ref != nil && len(child.ref) != n.refBytesSize {
+		v.add(path, child.ref, fmt.Errorf("reference size %d, expected %d: %w", len(child.ref), n.refBytesSize, ErrInvalidNode))
+	}
+	if len(f.prefix) == 0 || len(f.prefix) > nodePrefixMaxSize {
+		v.add(path, child.ref, fmt.Errorf("prefix length %d: %w", len(f.prefix), ErrInvalidNode))
+	} else if f.prefix[0] != k {
+		v.add(path, child.ref, fmt.Errorf("prefix on fork '%x': %w", []byte{k}, ErrInvalidNode))
+	}
+	if bytes.IndexByte(f.prefix, PathSeparator) > 0 && !child.IsWithPathSeparatorType() {
+		v.add(path, child.ref, fmt.Errorf("path separator without flag: %w", ErrInvalidNode))
+	}
+	if child.IsWithMetadataType() != (len(child.metadata) > 0) {
+		v.add(path, child.ref, fmt.Errorf("metadata flag mismatch: %w", ErrInvalidNode))
+	}
+	if len(child.metadata) == 0 {
+		return nil
+	}
+	if n.ref != nil && isMetadataRef(child.metadata) {
+		ref, err := hex.DecodeString(child.metadata[metadataRefKey])
+		if err != nil {
+			v.add(path, nil, fmt.Errorf("metadata reference: %v: %w", err, ErrInvalidNode))
+			return nil
+		}
+		b, err := v.load(ctx, path, ref)
+		if err != nil || b == nil {
+			return err
+		}
+		var metadata map[string]string
+		if err := json.Unmarshal(b, &metadata); err != nil {
+			v.add(path, ref, fmt.Errorf("metadata: %v: %w", err, ErrInvalidNode))
+		}
+		return nil
+	}
+	b, err := json.Marshal(child.metadata)
+	if err != nil {
+		v.add(path, child.ref, fmt.Errorf("metadata: %v: %w", err, ErrInvalidNode))
+	} else if len(b)+nodeForkMetadataBytesSize > int(maxUint16) {
+		v.add(path, child.ref, fmt.Errorf("metadata size %d: %w", len(b), ErrMetadataTooLarge))
+	}
+	return nil
+}
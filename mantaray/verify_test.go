@@ -0,0 +1,213 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+func sha256Hash(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:]
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	entries := map[string]map[string]string{
+		"index.html": {"Content-Type": "text/html"},
+		"img/1.png":  nil,
+		"img/2.png":  nil,
+	}
+	for i := 0; i < 10; i++ {
+		p := fmt.Sprintf("doc/%c.txt", 'a'+i)
+		entries[p] = map[string]string{"Description": strings.Repeat(p, 100)}
+	}
+	ref := savedManifestMax(t, ls, entries, mantaray.ChunkSize)
+
+	r, err := mantaray.Verify(ctx, mantaray.NewNodeRef(ref), ls, sha256Hash)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !r.OK() {
+		t.Fatalf("expected no violations, got %v", r.Violations)
+	}
+	nodes := 0
+	err = mantaray.NewNodeRef(ref).WalkNode(ctx, []byte{}, ls, func(_ []byte, _ *mantaray.Node, err error) error {
+		nodes++
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if r.Nodes != nodes {
+		t.Fatalf("expected %d nodes, got %d", nodes, r.Nodes)
+	}
+
+	// corrupt the node of a file
+	node, err := mantaray.NewNodeRef(ref).LookupNode(ctx, []byte("img/2.png"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var a addr
+	copy(a[:], node.Reference())
+	corrupt := append([]byte(nil), ls.store[a]...)
+	// flip a byte of the entry, which follows the header
+	corrupt[64] ^= 0xff
+	ls.store[a] = corrupt
+
+	r, err = mantaray.Verify(ctx, mantaray.NewNodeRef(ref), ls, sha256Hash)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(r.Violations) != 1 {
+		t.Fatalf("expected one violation, got %v", r.Violations)
+	}
+	if v := r.Violations[0]; string(v.Path) != "img/2.png" || !errors.Is(v.Err, mantaray.ErrHashMismatch) {
+		t.Fatalf("expected hash mismatch on img/2.png, got %v", v)
+	}
+	if r.Nodes != nodes {
+		t.Fatalf("expected %d nodes, got %d", nodes, r.Nodes)
+	}
+}
+
+func TestVerifyStructure(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := mantaray.New()
+	n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+	for _, p := range []string{"a/b.txt", "a/c.txt"} {
+		if err := n.Add(ctx, []byte(p), testEntry(p), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	data, err := ls.Load(ctx, n.Reference())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// clear the edge and path separator flags of the only fork, 'a/', which
+	// follows the header, the entry and the fork bitmap
+	const forkOffset = 64 + 32 + 32
+	data = append([]byte(nil), data...)
+	data[forkOffset] &^= 4 | 8
+	ref, err := ls.Save(ctx, data)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	r, err := mantaray.Verify(ctx, mantaray.NewNodeRef(ref), ls, sha256Hash)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(r.Violations) != 2 {
+		t.Fatalf("expected two violations, got %v", r.Violations)
+	}
+	for _, v := range r.Violations {
+		if string(v.Path) != "a/" || !errors.Is(v.Err, mantaray.ErrInvalidNode) {
+			t.Fatalf("expected invalid node on a/, got %v", v)
+		}
+	}
+}
+
+func TestVerifyEmpty(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := mantaray.New()
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	r, err := mantaray.Verify(ctx, mantaray.NewNodeRef(n.Reference()), ls, sha256Hash)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !r.OK() || r.Nodes != 1 {
+		t.Fatalf("expected a single valid node, got %d nodes and violations %v", r.Nodes, r.Violations)
+	}
+}
+
+// wideLoadSaver returns references of 64 bytes, as encrypted references.
+type wideLoadSaver struct {
+	mantaray.LoadSaver
+}
+
+func (ls wideLoadSaver) Save(ctx context.Context, b []byte) ([]byte, error) {
+	ref, err := ls.LoadSaver.Save(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	return append(ref, ref...), nil
+}
+
+func (ls wideLoadSaver) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	return ls.LoadSaver.Load(ctx, ref[:len(ref)/2])
+}
+
+func wideHash(b []byte) []byte {
+	h := sha256Hash(b)
+	return append(h, h...)
+}
+
+func TestVerifyEntrySize(t *testing.T) {
+	ctx := context.Background()
+	ls := wideLoadSaver{newMockLoadSaver()}
+	n := mantaray.New()
+	for _, p := range []string{"a/b.txt", "a/c.txt", "d.txt"} {
+		if err := n.Add(ctx, []byte(p), wideHash([]byte(p)), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	r, err := mantaray.Verify(ctx, mantaray.NewNodeRef(n.Reference()), ls, wideHash)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !r.OK() || r.Nodes != 5 {
+		t.Fatalf("expected 5 valid nodes, got %d nodes and violations %v", r.Nodes, r.Violations)
+	}
+}
+
+func TestVerifyTrailingData(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	ref := savedManifest(t, ls, map[string]map[string]string{
+		"img/1.png": nil,
+		"img/2.png": nil,
+	})
+	node, err := mantaray.NewNodeRef(ref).LookupNode(ctx, []byte("img/2.png"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// trailing data changes the hash and is rejected by the decoder
+	var a addr
+	copy(a[:], node.Reference())
+	ls.store[a] = append(append([]byte(nil), ls.store[a]...), 0)
+
+	r, err := mantaray.Verify(ctx, mantaray.NewNodeRef(ref), ls, sha256Hash)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(r.Violations) != 2 {
+		t.Fatalf("expected two violations, got %v", r.Violations)
+	}
+	if v := r.Violations[0]; string(v.Path) != "img/2.png" || !errors.Is(v.Err, mantaray.ErrHashMismatch) {
+		t.Fatalf("expected hash mismatch on img/2.png, got %v", v)
+	}
+	if v := r.Violations[1]; string(v.Path) != "img/2.png" || !errors.Is(v.Err, mantaray.ErrInvalidNode) {
+		t.Fatalf("expected invalid node on img/2.png, got %v", v)
+	}
+}
@@ -4,7 +4,10 @@
 
 package mantaray
 
-import "context"
+import (
+	"context"
+	"sync"
+)
 
 // WalkNodeFunc is the type of the function called for each node visited
 // by WalkNode.
@@ -18,16 +21,20 @@ func walkNodeFnCopyBytes(ctx context.Context, path []byte, node *Node, err error
 func walkNode(ctx context.Context, path []byte, l Loader, n *Node, walkFn WalkNodeFunc) error {
 	if n.forks == nil {
 		if err := n.load(ctx, l); err != nil {
-			return err
+			return &LoadError{Op: "walk", Path: copyBytes(path), Ref: n.ref, Err: err}
 		}
 	}
+	if err := n.resolveMetadata(ctx, l); err != nil {
+		return &LoadError{Op: "walk", Path: copyBytes(path), Ref: n.ref, Err: err}
+	}
 
 	err := walkNodeFnCopyBytes(ctx, path, n, nil, walkFn)
 	if err != nil {
 		return err
 	}
 
-	for _, v := range n.forks {
+	for _, k := range sortedKeys(n.forks) {
+		v := n.forks[k]
 		nextPath := append(path[:0:0], path...)
 		nextPath = append(nextPath, v.prefix...)
 
@@ -41,8 +48,8 @@ func walkNode(ctx context.Context, path []byte, l Loader, n *Node, walkFn WalkNo
 }
 
 // WalkNode walks the node tree structure rooted at root, calling walkFn for
-// each node in the tree, including root. All errors that arise visiting nodes
-// are filtered by walkFn.
+// each node in the tree, including root, in lexicographic order of paths.
+// All errors that arise visiting nodes are filtered by walkFn.
 func (n *Node) WalkNode(ctx context.Context, root []byte, l Loader, walkFn WalkNodeFunc) error {
 	node, err := n.LookupNode(ctx, root, l)
 	if err != nil {
@@ -61,12 +68,60 @@ func walkFnCopyBytes(path []byte, isDir bool, err error, walkFn WalkFunc) error
 	return walkFn(append(path[:0:0], path...), isDir, nil)
 }
 
-// walk recursively descends path, calling walkFn.
-func walk(ctx context.Context, path, prefix []byte, l Loader, n *Node, walkFn WalkFunc) error {
-	if n.forks == nil {
-		if err := n.load(ctx, l); err != nil {
-			return err
-		}
+// nodeLoader loads the nodes visited by walk.
+type nodeLoader interface {
+	// load loads n if its forks are not in memory.
+	load(ctx context.Context, n *Node, l Loader) error
+	// prefetch is called with each loaded node before its forks are
+	// visited.
+	prefetch(ctx context.Context, n *Node, l Loader) error
+}
+
+// directLoader loads nodes when they are visited.
+type directLoader struct{}
+
+func (directLoader) load(ctx context.Context, n *Node, l Loader) error {
+	if n.forks != nil {
+		return nil
+	}
+	return n.load(ctx, l)
+}
+
+func (directLoader) prefetch(context.Context, *Node, Loader) error {
+	return nil
+}
+
+// levelLoader loads the forks of a visited node together through a
+// BatchLoader.
+type levelLoader struct {
+	directLoader
+}
+
+func (levelLoader) prefetch(ctx context.Context, n *Node, l Loader) error {
+	return loadAll(ctx, l, forkNodes(n))
+}
+
+// sequentialLoader returns the nodeLoader used by Walk.
+func sequentialLoader(l Loader) nodeLoader {
+	if _, ok := l.(BatchLoader); ok {
+		return levelLoader{}
+	}
+	return directLoader{}
+}
+
+// walk recursively descends path, calling walkFn. Nodes are loaded through
+// p.
+func walk(ctx context.Context, path, prefix []byte, l Loader, p nodeLoader, n *Node, walkFn WalkFunc) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if err := p.load(ctx, n, l); err != nil {
+		return &LoadError{Op: "walk", Path: appendPath(path, prefix), Ref: n.ref, Err: err}
+	}
+	if err := p.prefetch(ctx, n, l); err != nil {
+		return &LoadError{Op: "walk", Path: appendPath(path, prefix), Ref: n.ref, Err: err}
 	}
 
 	nextPath := append(path[:0:0], path...)
@@ -93,12 +148,13 @@ func walk(ctx context.Context, path, prefix []byte, l Loader, n *Node, walkFn Wa
 		}
 	}
 
-	if n.IsEdgeType() {
-		for _, v := range n.forks {
-			err := walk(ctx, nextPath, v.prefix, l, v.Node, walkFn)
-			if err != nil {
-				return err
-			}
+	// the type of a loaded root is not persisted, so forks are visited
+	// regardless of the edge flag
+	for _, k := range sortedKeys(n.forks) {
+		v := n.forks[k]
+		err := walk(ctx, nextPath, v.prefix, l, p, v.Node, walkFn)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -106,12 +162,159 @@ func walk(ctx context.Context, path, prefix []byte, l Loader, n *Node, walkFn Wa
 }
 
 // Walk walks the node tree structure rooted at root, calling walkFn for
-// each file or directory in the tree, including root. All errors that arise
-// visiting files and directories are filtered by walkFn.
+// each file or directory in the tree, including root, in lexicographic
+// order of paths. All errors that arise visiting files and directories are
+// filtered by walkFn. If l is a BatchLoader, the forks of each visited
+// node are loaded with one call.
 func (n *Node) Walk(ctx context.Context, root []byte, l Loader, walkFn WalkFunc) error {
 	node, err := n.LookupNode(ctx, root, l)
 	if err != nil {
 		return walkFn(root, false, err)
 	}
-	return walk(ctx, root, []byte{}, l, node, walkFn)
+	return walk(ctx, root, []byte{}, l, sequentialLoader(l), node, walkFn)
+}
+
+// WalkParallel is like Walk, but loads nodes ahead of the callbacks with
+// up to workers concurrent calls to l. Callbacks are still made in order
+// from the calling goroutine.
+func (n *Node) WalkParallel(ctx context.Context, root []byte, l Loader, workers int, walkFn WalkFunc) error {
+	node, err := n.LookupNode(ctx, root, l)
+	if err != nil {
+		return walkFn(root, false, err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p := newPrefetcher(ctx, l, workers)
+	defer p.close()
+	return walk(ctx, root, []byte{}, l, p, node, walkFn)
+}
+
+// prefetcher loads the children of visited nodes with a pool of workers.
+type prefetcher struct {
+	ctx context.Context
+	l   Loader
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []*prefetch
+	pending map[string]*prefetch
+	closed  bool
+}
+
+// prefetch is the data of a reference loaded by a worker.
+type prefetch struct {
+	ref     []byte
+	count   int  // visits expecting the reference
+	started bool // taken by a worker or the walker
+	done    chan struct{}
+	data    []byte
+	err     error
+}
+
+func newPrefetcher(ctx context.Context, l Loader, workers int) *prefetcher {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &prefetcher{
+		ctx:     ctx,
+		l:       l,
+		pending: make(map[string]*prefetch),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *prefetcher) work() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		f := p.queue[0]
+		p.queue = p.queue[1:]
+		if f.started {
+			p.mu.Unlock()
+			continue
+		}
+		f.started = true
+		p.mu.Unlock()
+
+		f.data, f.err = p.l.Load(p.ctx, f.ref)
+		close(f.done)
+	}
+}
+
+// prefetch queues the unloaded children of n in walk order.
+func (p *prefetcher) prefetch(_ context.Context, n *Node, _ Loader) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, k := range sortedKeys(n.forks) {
+		c := n.forks[k].Node
+		if c.forks != nil || c.ref == nil {
+			continue
+		}
+		key := string(c.ref)
+		if f, ok := p.pending[key]; ok {
+			f.count++
+			continue
+		}
+		f := &prefetch{ref: c.ref, count: 1, done: make(chan struct{})}
+		p.pending[key] = f
+		p.queue = append(p.queue, f)
+	}
+	p.cond.Broadcast()
+	return nil
+}
+
+// load loads n with the prefetched data. A reference that no worker
+// started to load yet is loaded directly rather than waited for.
+func (p *prefetcher) load(ctx context.Context, n *Node, l Loader) error {
+	if n.forks != nil {
+		return nil
+	}
+	if n.ref == nil {
+		return n.load(ctx, l)
+	}
+	key := string(n.ref)
+	p.mu.Lock()
+	f, ok := p.pending[key]
+	if !ok {
+		p.mu.Unlock()
+		return n.load(ctx, l)
+	}
+	f.count--
+	if f.count == 0 {
+		delete(p.pending, key)
+	}
+	inline := !f.started
+	f.started = true
+	p.mu.Unlock()
+
+	if inline {
+		f.data, f.err = l.Load(ctx, f.ref)
+		close(f.done)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.done:
+	}
+	if f.err != nil {
+		return f.err
+	}
+	return n.UnmarshalBinary(f.data)
+}
+
+func (p *prefetcher) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
 }
@@ -0,0 +1,119 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/manifest/mantaray"
+)
+
+// slowLoader delays loads and records the highest number of concurrent ones.
+type slowLoader struct {
+	mantaray.Loader
+	delay time.Duration
+
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (l *slowLoader) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	l.mu.Lock()
+	l.current++
+	if l.current > l.max {
+		l.max = l.current
+	}
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.current--
+		l.mu.Unlock()
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(l.delay):
+	}
+	return l.Loader.Load(ctx, ref)
+}
+
+func TestWalkParallel(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	entries := make(map[string]map[string]string)
+	for i := 0; i < 60; i++ {
+		entries[fmt.Sprintf("dir%d/sub%d/file%d.txt", i%5, i%3, i)] = nil
+	}
+	ref := savedManifest(t, ls, entries)
+
+	type visit struct {
+		path  string
+		isDir bool
+	}
+	collect := func(visits *[]visit) mantaray.WalkFunc {
+		return func(path []byte, isDir bool, err error) error {
+			if err != nil {
+				return err
+			}
+			*visits = append(*visits, visit{string(path), isDir})
+			return nil
+		}
+	}
+
+	var want []visit
+	if err := mantaray.NewNodeRef(ref).Walk(ctx, []byte{}, ls, collect(&want)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	sl := &slowLoader{Loader: ls, delay: time.Millisecond}
+	var got []visit
+	if err := mantaray.NewNodeRef(ref).WalkParallel(ctx, []byte{}, sl, 4, collect(&got)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected visits %v, got %v", want, got)
+	}
+	// the walker may load a node itself next to the workers
+	if sl.max > 4+1 {
+		t.Fatalf("expected at most 5 concurrent loads, got %d", sl.max)
+	}
+}
+
+func TestWalkParallelCancel(t *testing.T) {
+	ls := newMockLoadSaver()
+	entries := make(map[string]map[string]string)
+	for i := 0; i < 20; i++ {
+		entries[fmt.Sprintf("dir%d/file%d.txt", i%4, i)] = nil
+	}
+	ref := savedManifest(t, ls, entries)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sl := &slowLoader{Loader: ls, delay: time.Millisecond}
+	visits := 0
+	err := mantaray.NewNodeRef(ref).WalkParallel(ctx, []byte{}, sl, 2, func(_ []byte, _ bool, err error) error {
+		if err != nil {
+			return err
+		}
+		visits++
+		if visits == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled error, got %v", err)
+	}
+	if visits != 3 {
+		t.Fatalf("expected walk to stop after 3 visits, got %d", visits)
+	}
+}